@@ -2,20 +2,28 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 
 	"github.com/n-r-w/lg"
 	"github.com/n-r-w/updsrv/internal/app"
+	"github.com/n-r-w/updsrv/internal/cli"
 	"github.com/n-r-w/updsrv/internal/config"
 )
 
 func main() {
 	var configPath string
 	// описание флагов командной строки
-	flag.StringVar(&configPath, "config-path", "", "path to config file")
+	flag.StringVar(&configPath, "config-path", os.Getenv(config.EnvPrefix+"CONFIG_PATH"), "path to config file")
 
 	// обработка командной строки
 	flag.Parse()
 
+	// клиентские команды работают с сервером по http и не требуют его конфига
+	if flag.NArg() > 0 && cli.IsCommand(flag.Arg(0)) {
+		os.Exit(cli.Run(flag.Arg(0), flag.Args()[1:], os.Stdout, os.Stderr))
+	}
+
 	log := lg.New()
 
 	// читаем конфиг
@@ -26,5 +34,19 @@ func main() {
 		return
 	}
 
-	app.Start(cfg, log)
+	// команды. Без команды запускается сервер
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "migrate":
+			err = app.Migrate(cfg, flag.Args()[1:], log)
+		default:
+			err = fmt.Errorf("unknown command: %s", flag.Arg(0))
+		}
+		if err != nil {
+			log.Fatal("%v", err)
+		}
+		return
+	}
+
+	app.Start(cfg, configPath, log)
 }
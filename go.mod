@@ -1,11 +1,12 @@
 module github.com/n-r-w/updsrv
 
-go 1.18
+go 1.22
 
 require (
 	github.com/BurntSushi/toml v1.1.0
 	github.com/google/wire v0.5.0
 	github.com/jackc/pgerrcode v0.0.0-20220416144525-469b46aa5efa
+	github.com/klauspost/compress v1.18.0
 	github.com/n-r-w/eno v1.0.2
 	github.com/n-r-w/httprouter v1.1.0
 	github.com/n-r-w/httpserver v1.1.0
@@ -15,10 +16,13 @@ require (
 	github.com/n-r-w/sqlb v1.1.1
 	github.com/n-r-w/sqlq v1.1.0
 	github.com/n-r-w/tools v1.0.2
+	github.com/prometheus/client_golang v1.20.5
 	golang.org/x/time v0.0.0-20220609170525-579cf78fd858
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/felixge/httpsnoop v1.0.1 // indirect
 	github.com/go-ozzo/ozzo-validation/v4 v4.3.0 // indirect
 	github.com/google/uuid v1.3.0 // indirect
@@ -36,10 +40,15 @@ require (
 	github.com/jackc/pgx/v4 v4.16.1 // indirect
 	github.com/jackc/puddle v1.2.1 // indirect
 	github.com/lib/pq v1.10.6 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.55.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
 	github.com/sirupsen/logrus v1.8.1 // indirect
 	github.com/thanhpk/randstr v1.0.4 // indirect
 	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e // indirect
 	golang.org/x/exp v0.0.0-20220613132600-b0d781184e0d // indirect
-	golang.org/x/sys v0.0.0-20211019181941-9d821ace8654 // indirect
-	golang.org/x/text v0.3.7 // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
 )
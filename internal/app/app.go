@@ -2,8 +2,10 @@
 package app
 
 import (
+	"context"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -16,7 +18,8 @@ import (
 
 const version = "1.1.0"
 
-func Start(cfg *config.Config, logger lg.Logger) {
+// Start запуск сервера. configPath нужен для перечитывания конфига по сигналу SIGHUP
+func Start(cfg *config.Config, configPath string, logger lg.Logger) {
 	logger.Info("updsrv %s", version)
 
 	// инициализация DI контейнера
@@ -31,23 +34,49 @@ func Start(cfg *config.Config, logger lg.Logger) {
 		return
 	}
 
-	// запускаем http сервер
-	httpServer := httpserver.New(con.Router.Handler(), logger,
-		httpserver.Address(con.Config.Host, con.Config.Port),
-		httpserver.ReadTimeout(time.Second*time.Duration(con.Config.HttpReadTimeout)),
-		httpserver.WriteTimeout(time.Second*time.Duration(con.Config.HttpWriteTimeout)),
-		httpserver.ShutdownTimeout(time.Second*time.Duration(con.Config.HttpShutdownTimeout)),
-	)
+	// сервер не работает со схемой БД старее, чем ожидает код
+	if err := con.Repo.CheckSchemaVersion(context.Background()); err != nil {
+		logger.Err(err)
+		return
+	}
+
+	handler := con.Presenter.Handler(con.Router.Handler())
+
+	// запускаем http или https сервер
+	var httpServer server
+	if len(con.Config.TlsCertFile) > 0 {
+		if httpServer, err = newTLSServer(handler, con.Config, logger); err != nil {
+			logger.Err(err)
+			return
+		}
+	} else {
+		httpServer = httpserver.New(handler, logger,
+			httpserver.Address(con.Config.Host, con.Config.Port),
+			httpserver.ReadTimeout(time.Second*time.Duration(con.Config.HttpReadTimeout)),
+			httpserver.WriteTimeout(time.Second*time.Duration(con.Config.HttpWriteTimeout)),
+			httpserver.ShutdownTimeout(time.Second*time.Duration(con.Config.HttpShutdownTimeout)),
+		)
+	}
 
 	// ждем сигнал от сервера или нажатия ctrl+c
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	// перечитывание конфига
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
 
-	select {
-	case <-interrupt:
-		logger.Info("shutdown, timeout %d ...", cfg.HttpShutdownTimeout)
-	case err := <-httpServer.Notify():
-		logger.Error("http server notification: %v", err)
+loop:
+	for {
+		select {
+		case <-hangup:
+			reloadConfig(con, configPath, logger)
+		case <-interrupt:
+			logger.Info("shutdown, timeout %d ...", cfg.HttpShutdownTimeout)
+			break loop
+		case err := <-httpServer.Notify():
+			logger.Error("http server notification: %v", err)
+			break loop
+		}
 	}
 
 	// ждем завершения
@@ -58,4 +87,31 @@ func Start(cfg *config.Config, logger lg.Logger) {
 		logger.Info("shutdown ok")
 	}
 
+	// запросы завершены, записываем накопленные события
+	con.Repo.CloseEvents()
+
+}
+
+// reloadConfig перечитывание конфига и применение параметров, которые меняются без перезапуска.
+// Некорректный конфиг не применяется
+func reloadConfig(con *di.Container, configPath string, logger lg.Logger) {
+	logger.Info("reload config %s ...", configPath)
+
+	cfg, err := config.New(configPath, logger)
+	if err != nil {
+		logger.Error("reload config rejected: %v", err)
+		return
+	}
+
+	if err := con.Presenter.Reload(cfg); err != nil {
+		logger.Error("reload config rejected: %v", err)
+		return
+	}
+	con.Repo.Reload(cfg)
+
+	// сравниваем с конфигом, с которым сервер был запущен
+	if restart := config.RestartRequired(con.Config, cfg); len(restart) > 0 {
+		logger.Warn("reload config: restart required to apply %s", strings.Join(restart, ", "))
+	}
+	logger.Info("reload config ok")
 }
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/n-r-w/lg"
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/postgres"
+	"github.com/n-r-w/updsrv/internal/config"
+	"github.com/n-r-w/updsrv/internal/metrics"
+	"github.com/n-r-w/updsrv/internal/repo/psql"
+)
+
+const migrateUsage = "usage: updsrv migrate up | down [count] | status | baseline <version>"
+
+// Migrate команда migrate: изменение схемы БД встроенными миграциями
+func Migrate(cfg *config.Config, args []string, logger lg.Logger) error {
+	if len(args) == 0 {
+		return nerr.New(migrateUsage)
+	}
+
+	pg, err := postgres.New(postgres.Url(cfg.DatabaseURL), logger, postgres.MaxConns(1))
+	if err != nil {
+		return err
+	}
+	repo := psql.NewRepo(pg, cfg, logger, metrics.New(nil))
+	ctx := context.Background()
+
+	var versions []string
+	switch args[0] {
+	case "up":
+		versions, err = repo.MigrateUp(ctx)
+
+	case "down":
+		// по умолчанию откатываем одну миграцию
+		count := 1
+		if len(args) > 1 {
+			if count, err = strconv.Atoi(args[1]); err != nil || count < 1 {
+				return nerr.NewFmt("invalid count: %s", args[1])
+			}
+		}
+		versions, err = repo.MigrateDown(count, ctx)
+
+	case "baseline":
+		if len(args) < 2 {
+			return nerr.New(migrateUsage)
+		}
+		versions, err = repo.MigrateBaseline(args[1], ctx)
+
+	case "status":
+		return printMigrations(repo, ctx)
+
+	default:
+		return nerr.New(migrateUsage)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(versions) == 0 {
+		logger.Info("migrate %s: nothing to do", args[0])
+	}
+	for _, v := range versions {
+		logger.Info("migrate %s: %s", args[0], v)
+	}
+	return nil
+}
+
+func printMigrations(repo *psql.Repo, ctx context.Context) error {
+	status, err := repo.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATUS\tAPPLIED AT")
+	for _, s := range status {
+		if s.Applied {
+			fmt.Fprintf(w, "%s\tapplied\t%s\n", s.Version, s.ApplyTime.Format(time.RFC3339))
+		} else {
+			fmt.Fprintf(w, "%s\tpending\t\n", s.Version)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/n-r-w/lg"
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/updsrv/internal/config"
+)
+
+// server общий интерфейс http и https серверов
+type server interface {
+	Notify() <-chan error
+	Shutdown() error
+}
+
+// tlsServer https сервер с необязательной проверкой клиентских сертификатов
+type tlsServer struct {
+	server          *http.Server
+	notify          chan error
+	shutdownTimeout time.Duration
+}
+
+func newTLSServer(handler http.Handler, cfg *config.Config, logger lg.Logger) (*tlsServer, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &tlsServer{
+		server: &http.Server{
+			Handler:      handler,
+			Addr:         net.JoinHostPort(cfg.Host, cfg.Port),
+			ReadTimeout:  time.Second * time.Duration(cfg.HttpReadTimeout),
+			WriteTimeout: time.Second * time.Duration(cfg.HttpWriteTimeout),
+			TLSConfig:    tlsConfig,
+		},
+		notify:          make(chan error, 1),
+		shutdownTimeout: time.Second * time.Duration(cfg.HttpShutdownTimeout),
+	}
+
+	go func() {
+		logger.Info("https server started on %s", s.server.Addr)
+		// сертификат и ключ уже загружены в TLSConfig
+		s.notify <- s.server.ListenAndServeTLS("", "")
+		close(s.notify)
+	}()
+
+	return s, nil
+}
+
+func (s *tlsServer) Notify() <-chan error {
+	return s.notify
+}
+
+func (s *tlsServer) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	return s.server.Shutdown(ctx)
+}
+
+// настройки TLS из конфига
+func newTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.TlsCertFile, cfg.TlsKeyFile)
+	if err != nil {
+		return nil, nerr.New(err)
+	}
+
+	minVersion, ok := map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}[cfg.TlsMinVersion]
+	if !ok {
+		return nil, nerr.NewFmt("invalid TLS_MIN_VERSION: %s", cfg.TlsMinVersion)
+	}
+
+	res := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+	}
+
+	if len(cfg.TlsClientCAFile) > 0 {
+		data, err := os.ReadFile(cfg.TlsClientCAFile)
+		if err != nil {
+			return nil, nerr.New(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, nerr.NewFmt("no certificates in %s", cfg.TlsClientCAFile)
+		}
+
+		res.ClientCAs = pool
+		if cfg.TlsClientCertRequired {
+			res.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			// клиенты без сертификата аутентифицируются токенами
+			res.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,122 @@
+// Package cli Клиентские команды updsrv для сборочных конвейеров: публикация и просмотр обновлений на работающем сервере
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/n-r-w/updsrv/pkg/client"
+)
+
+// Коды завершения
+const (
+	ExitOK       = 0 // успешно
+	ExitError    = 1 // ошибка выполнения
+	ExitUsage    = 2 // неверные параметры
+	ExitNoUpdate = 3 // обновление не найдено (check, download)
+)
+
+// переменные окружения со значениями по умолчанию для -server и -token
+const (
+	envServer = "UPDSRV_SERVER"
+	envToken  = "UPDSRV_TOKEN"
+)
+
+type command struct {
+	usage string
+	run   func(o *options, fs *flag.FlagSet, args []string) (interface{}, int, error)
+}
+
+var commands = map[string]command{
+	"publish":  {"publish -channel C -version V [-info I] [-enabled=false] [-build-time 2006-01-02T15:04] [-chunk-size MB [-resume ID]] [-base V [-remove FILE]...] [-dry-run] <dir or archive>", publish},
+	"check":    {"check -channel C -version V [-client-id ID]", check},
+	"download": {"download -channel C -version V [-client-id ID] [-o file.zip]", download},
+	"list":     {"list -channel C", list},
+}
+
+// options общие параметры команд
+type options struct {
+	server   string
+	token    string
+	clientID string
+	client   *client.Client
+}
+
+// IsCommand является ли name клиентской командой
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+// Run выполнение клиентской команды. Результат в формате json выводится в stdout, ошибка в stderr. Возвращает код завершения
+func Run(name string, args []string, stdout io.Writer, stderr io.Writer) int {
+	cmd, ok := commands[name]
+	if !ok {
+		return fail(stderr, ExitUsage, fmt.Errorf("unknown command: %s", name))
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: updsrv %s [-server URL] [-token TOKEN]\n", cmd.usage)
+		fs.PrintDefaults()
+	}
+
+	o := &options{}
+	fs.StringVar(&o.server, "server", os.Getenv(envServer), "server address, e.g. http://localhost:8080 ($"+envServer+")")
+	fs.StringVar(&o.token, "token", os.Getenv(envToken), "access token ($"+envToken+")")
+
+	res, code, err := cmd.run(o, fs, args)
+	if err != nil {
+		return fail(stderr, code, err)
+	}
+
+	if res != nil {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			return fail(stderr, ExitError, err)
+		}
+	}
+	return code
+}
+
+// разбор параметров команды и проверка обязательных
+func parseFlags(o *options, fs *flag.FlagSet, args []string, required ...string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if len(o.server) == 0 {
+		return fmt.Errorf("-server or %s required", envServer)
+	}
+
+	for _, name := range required {
+		if f := fs.Lookup(name); f == nil || len(f.Value.String()) == 0 {
+			return fmt.Errorf("-%s required", name)
+		}
+	}
+
+	o.client = client.New(o.server, o.token, client.WithClientID(o.clientID))
+	return nil
+}
+
+// вывод ошибки в формате json
+func fail(stderr io.Writer, code int, err error) int {
+	res := struct {
+		Error  string `json:"error"`
+		Status int    `json:"status,omitempty"`
+	}{Error: err.Error()}
+
+	var se *client.StatusError
+	if errors.As(err, &se) {
+		res.Status = se.StatusCode
+	}
+
+	_ = json.NewEncoder(stderr).Encode(res)
+	return code
+}
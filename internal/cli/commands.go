@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/n-r-w/updsrv/pkg/client"
+)
+
+// check проверка наличия обновления
+func check(o *options, fs *flag.FlagSet, args []string) (interface{}, int, error) {
+	channel, version, code, err := parseVersionFlags(o, fs, args)
+	if err != nil {
+		return nil, code, err
+	}
+
+	info, err := o.client.Check(context.Background(), channel, version)
+	if err != nil {
+		return nil, ExitError, err
+	}
+	if info == nil {
+		return nil, ExitNoUpdate, fmt.Errorf("no update for %s %s", channel, version.String())
+	}
+	return info, ExitOK, nil
+}
+
+// download загрузка обновления в zip файл
+func download(o *options, fs *flag.FlagSet, args []string) (interface{}, int, error) {
+	var output string
+	fs.StringVar(&output, "o", "", "output file (default update_<channel>_<version>.zip)")
+
+	channel, version, code, err := parseVersionFlags(o, fs, args)
+	if err != nil {
+		return nil, code, err
+	}
+
+	pkg, err := o.client.Download(context.Background(), channel, version)
+	if err != nil {
+		return nil, ExitError, err
+	}
+	if pkg == nil {
+		return nil, ExitNoUpdate, fmt.Errorf("no update for %s %s", channel, version.String())
+	}
+	defer pkg.Close()
+
+	if len(output) == 0 {
+		output = fmt.Sprintf("update_%s_%s.zip", channel, pkg.Version.String())
+	}
+	if err := copyFile(pkg.Path, output); err != nil {
+		return nil, ExitError, err
+	}
+
+	return struct {
+		File      string         `json:"file"`
+		Size      int64          `json:"size"`
+		Channel   string         `json:"channel"`
+		Version   client.Version `json:"version"`
+		BuildTime string         `json:"buildTime,omitempty"`
+	}{output, pkg.Size, channel, pkg.Version, pkg.BuildTime}, ExitOK, nil
+}
+
+// list список версий канала
+func list(o *options, fs *flag.FlagSet, args []string) (interface{}, int, error) {
+	var channel string
+	fs.StringVar(&channel, "channel", "", "update channel")
+	if err := parseFlags(o, fs, args, "channel"); err != nil {
+		return nil, ExitUsage, err
+	}
+
+	res, err := o.client.Versions(context.Background(), channel)
+	if err != nil {
+		return nil, ExitError, err
+	}
+	return res, ExitOK, nil
+}
+
+// параметры -channel и -version
+func parseVersionFlags(o *options, fs *flag.FlagSet, args []string) (string, client.Version, int, error) {
+	var channel, version string
+	fs.StringVar(&channel, "channel", "", "update channel")
+	fs.StringVar(&version, "version", "", "current version, e.g. 4.1.2.9")
+	fs.StringVar(&o.clientID, "client-id", "", "client identifier")
+
+	if err := parseFlags(o, fs, args, "channel", "version"); err != nil {
+		return "", client.Version{}, ExitUsage, err
+	}
+
+	v, err := client.ParseVersion(version)
+	if err != nil {
+		return "", client.Version{}, ExitUsage, err
+	}
+	return channel, v, ExitOK, nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
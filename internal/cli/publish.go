@@ -0,0 +1,221 @@
+package cli
+
+import (
+	"archive/zip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/n-r-w/updsrv/pkg/client"
+)
+
+// stringList флаг, который можно указать несколько раз
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// publish публикация новой версии: каталог упаковывается в zip, архив (zip, tar, tar.gz, tar.zst) отправляется как есть.
+// С -base публикуются только новые и измененные файлы, остальные сервер берет из базовой версии
+func publish(o *options, fs *flag.FlagSet, args []string) (interface{}, int, error) {
+	var req client.PublishRequest
+	var version, buildTime, base string
+	var removed stringList
+	fs.StringVar(&req.Channel, "channel", "", "update channel")
+	fs.StringVar(&version, "version", "", "version, e.g. 4.1.2.9")
+	fs.StringVar(&req.Info, "info", "", "version description")
+	fs.BoolVar(&req.Enabled, "enabled", true, "enable update to this version")
+	fs.StringVar(&buildTime, "build-time", "", "build time in format 2006-01-02T15:04 (default now)")
+	chunkSize := fs.Int("chunk-size", 0, "upload in chunks of this size in megabytes (0 - single request)")
+	resume := fs.String("resume", "", "continue chunked upload session with this id")
+	fs.StringVar(&base, "base", "", "publish only changed files on top of this version of the channel")
+	dryRun := fs.Bool("dry-run", false, "validate the archive and show changes against the latest version without publishing")
+	fs.Var(&removed, "remove", "file of the base version removed in the new version (repeatable, requires -base)")
+
+	if err := parseFlags(o, fs, args, "channel", "version"); err != nil {
+		return nil, ExitUsage, err
+	}
+	if fs.NArg() > 1 || (fs.NArg() == 0 && len(base) == 0) {
+		return nil, ExitUsage, fmt.Errorf("path to directory or archive file required")
+	}
+
+	var err error
+	if req.Version, err = client.ParseVersion(version); err != nil {
+		return nil, ExitUsage, err
+	}
+	if len(buildTime) > 0 {
+		if req.BuildTime, err = time.Parse("2006-01-02T15:04", buildTime); err != nil {
+			return nil, ExitUsage, fmt.Errorf("invalid -build-time: %v", err)
+		}
+	}
+	if len(base) > 0 {
+		baseVersion, err := client.ParseVersion(base)
+		if err != nil {
+			return nil, ExitUsage, fmt.Errorf("invalid -base: %v", err)
+		}
+		if *chunkSize > 0 || len(*resume) > 0 {
+			return nil, ExitUsage, fmt.Errorf("-base can not be used with -chunk-size")
+		}
+		req.Base = &baseVersion
+		req.Removed = removed
+	} else if len(removed) > 0 {
+		return nil, ExitUsage, fmt.Errorf("-remove requires -base")
+	}
+
+	if *dryRun && (*chunkSize > 0 || len(*resume) > 0) {
+		return nil, ExitUsage, fmt.Errorf("-dry-run can not be used with -chunk-size")
+	}
+
+	// при наложении без архива файлы только удаляются
+	if fs.NArg() == 0 {
+		if *dryRun {
+			return previewResult(o.client.PreviewPublish(context.Background(), req, nil))
+		}
+		receipt, err := o.client.Publish(context.Background(), req, nil)
+		if err != nil {
+			return nil, ExitError, err
+		}
+		return publishResult{receipt, req.Enabled, 0}, ExitOK, nil
+	}
+
+	path := fs.Arg(0)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, ExitError, err
+	}
+
+	zipPath := path
+	if stat.IsDir() {
+		if zipPath, err = zipDir(path); err != nil {
+			return nil, ExitError, err
+		}
+		defer os.Remove(zipPath)
+	}
+
+	zipFile, err := os.Open(zipPath)
+	if err != nil {
+		return nil, ExitError, err
+	}
+	defer zipFile.Close()
+
+	zipStat, err := zipFile.Stat()
+	if err != nil {
+		return nil, ExitError, err
+	}
+
+	var receipt *client.PublishReceipt
+	switch {
+	case *dryRun:
+		return previewResult(o.client.PreviewPublish(context.Background(), req, zipFile))
+	case len(*resume) > 0:
+		if *chunkSize <= 0 {
+			return nil, ExitUsage, fmt.Errorf("-resume requires -chunk-size")
+		}
+		receipt, err = o.client.ResumeUpload(context.Background(), *resume, zipFile, zipStat.Size(), int64(*chunkSize)<<20)
+	case *chunkSize > 0:
+		// большие архивы частями, при обрыве загрузку можно продолжить с -resume
+		receipt, err = o.client.PublishChunked(context.Background(), req, zipFile, zipStat.Size(), int64(*chunkSize)<<20)
+	default:
+		receipt, err = o.client.Publish(context.Background(), req, zipFile)
+	}
+	if err != nil {
+		return nil, ExitError, err
+	}
+
+	return publishResult{receipt, req.Enabled, zipStat.Size()}, ExitOK, nil
+}
+
+// previewResult результат publish -dry-run
+func previewResult(preview *client.PublishPreview, err error) (interface{}, int, error) {
+	if err != nil {
+		return nil, ExitError, err
+	}
+	return preview, ExitOK, nil
+}
+
+// publishResult результат команды publish: квитанция сервера и размер отправленного архива
+type publishResult struct {
+	*client.PublishReceipt
+	Enabled bool  `json:"enabled"`
+	Size    int64 `json:"size"`
+}
+
+// zipDir упаковка каталога во временный zip файл. Пути в архиве относительно каталога, через /
+func zipDir(dir string) (string, error) {
+	file, err := ioutil.TempFile("", "updsrvpub*.zip")
+	if err != nil {
+		return "", err
+	}
+
+	zw := zip.NewWriter(file)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		isLink := info.Mode()&os.ModeSymlink != 0
+		if !isLink && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		// права доступа, время изменения и ссылки сохраняются в архиве
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		header.Method = zip.Deflate
+
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		// содержимое символической ссылки - путь, на который она указывает
+		if isLink {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write([]byte(filepath.ToSlash(target)))
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
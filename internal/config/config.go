@@ -3,6 +3,10 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/n-r-w/lg"
@@ -22,11 +26,110 @@ type Config struct {
 	HttpShutdownTimeout  int      `toml:"HTTP_SHUTDOWN_TIMEOUT"`
 	RateLimit            int      `toml:"RATE_LIMIT"`
 	RateLimitBurst       int      `toml:"RATE_LIMIT_BURST"`
+	TrustedProxies       []string `toml:"TRUSTED_PROXIES"`
 	MaxUpdateSize        int      `toml:"MAX_UPDATE_SIZE"`
+	MaxUpdateFiles       int      `toml:"MAX_UPDATE_FILES"`
+	MaxUnpackedSize      int      `toml:"MAX_UNPACKED_SIZE"`
+	MaxUploadSize        int      `toml:"MAX_UPLOAD_SIZE"`
+	UploadChunkSize      int      `toml:"UPLOAD_CHUNK_SIZE"`
+	UploadSessionTTL     int      `toml:"UPLOAD_SESSION_TTL"`
 	MaxVersionCount      int      `toml:"MAX_VERSION_COUNT"`
 	MinVersionAge        int      `toml:"MIN_VERSION_AGE"`
 	TokensRead           []string `toml:"TOKENS_READ"`
 	TokensWrite          []string `toml:"TOKENS_WRITE"`
+	TokensAdmin          []string `toml:"TOKENS_ADMIN"`
+	TokensRefresh        int      `toml:"TOKENS_REFRESH"`
+	JwksPath             string   `toml:"JWT_JWKS_PATH"`
+	JwksReload           int      `toml:"JWT_JWKS_RELOAD"`
+	JwtAudience          string   `toml:"JWT_AUDIENCE"`
+	JwtIssuer            string   `toml:"JWT_ISSUER"`
+
+	TlsCertFile           string `toml:"TLS_CERT_FILE"`
+	TlsKeyFile            string `toml:"TLS_KEY_FILE"`
+	TlsMinVersion         string `toml:"TLS_MIN_VERSION"`
+	TlsClientCAFile       string `toml:"TLS_CLIENT_CA_FILE"`
+	TlsClientCertRequired bool   `toml:"TLS_CLIENT_CERT_REQUIRED"`
+
+	RateLimits  map[string]RateLimit   `toml:"RATE_LIMITS"`      // ограничения частоты запросов по методам API
+	ClientCerts map[string]ClientCert  `toml:"TLS_CLIENT_CERTS"` // права по клиентским сертификатам
+	Compression map[string]Compression `toml:"COMPRESSION"`      // сжатие архивов обновлений по каналам
+}
+
+// ClientCert права клиента, аутентифицированного по сертификату. Ключ - subject или CN сертификата
+type ClientCert struct {
+	Scopes   []string `toml:"SCOPES"`   // read, write, admin
+	Channels []string `toml:"CHANNELS"` // разрешенные каналы или шаблоны. Пустой - все каналы
+}
+
+// RateLimit ограничения частоты запросов к методу API. Нулевое значение - без ограничений
+type RateLimit struct {
+	Token      float64 `toml:"TOKEN"`       // запросов в секунду на один токен
+	TokenBurst int     `toml:"TOKEN_BURST"` // пиковое количество запросов на один токен
+	IP         float64 `toml:"IP"`          // запросов в секунду на один IP адрес
+	IPBurst    int     `toml:"IP_BURST"`    // пиковое количество запросов на один IP адрес
+}
+
+// Compression сжатие архивов обновлений. Ключ - канал или шаблон канала, "*" - все каналы
+type Compression struct {
+	ZipLevel  int      `toml:"ZIP_LEVEL"`  // уровень deflate для zip 1-9, 0 - по умолчанию
+	ZstdLevel int      `toml:"ZSTD_LEVEL"` // уровень zstd для tar.zst 1-22, 0 - по умолчанию
+	Store     []string `toml:"STORE"`      // расширения уже сжатых файлов, которые в zip сохраняются без сжатия
+}
+
+// Key отпечаток настроек сжатия. По нему в кэше отбрасываются архивы, собранные с прежними настройками
+func (c Compression) Key() string {
+	store := append([]string{}, c.Store...)
+	sort.Strings(store)
+	return fmt.Sprintf("zip=%d;zstd=%d;store=%s", c.ZipLevel, c.ZstdLevel, strings.Join(store, ","))
+}
+
+// DefaultStore расширения уже сжатых файлов, если для канала не задано сжатие
+var DefaultStore = []string{".zip", ".7z", ".gz", ".xz", ".zst", ".bz2", ".rar", ".jpg", ".jpeg", ".png", ".mp3", ".mp4"}
+
+// ChannelCompression настройки сжатия для канала: точное совпадение, затем шаблоны, затем значения по умолчанию
+func (c *Config) ChannelCompression(channel string) Compression {
+	if cmp, ok := c.Compression[channel]; ok {
+		return cmp
+	}
+
+	patterns := make([]string, 0, len(c.Compression))
+	for pattern := range c.Compression {
+		patterns = append(patterns, pattern)
+	}
+	// при нескольких подходящих шаблонах выбор не должен зависеть от порядка обхода map
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, channel); err == nil && ok {
+			return c.Compression[pattern]
+		}
+	}
+
+	return Compression{Store: DefaultStore}
+}
+
+// TrustedProxyNets сети прокси из TRUSTED_PROXIES, которым разрешено передавать адрес клиента в X-Real-IP.
+// Элемент списка - IP адрес или подсеть в формате CIDR
+func (c *Config) TrustedProxyNets() ([]*net.IPNet, error) {
+	var res []*net.IPNet
+	for _, s := range c.TrustedProxies {
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			} else {
+				ip = ip.To4()
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: invalid address %s", s)
+		}
+		res = append(res, ipNet)
+	}
+	return res, nil
 }
 
 const (
@@ -34,7 +137,7 @@ const (
 	maxDbSessionIdleTime = 50
 )
 
-// New Инициализация конфига значениями по умолчанию
+// New Инициализация конфига значениями по умолчанию, затем из config.toml, затем из переменных окружения UPDSRV_*
 func New(configPath string, logger lg.Logger) (*Config, error) {
 	c := &Config{
 		Host:                 "0.0.0.0",
@@ -49,11 +152,24 @@ func New(configPath string, logger lg.Logger) (*Config, error) {
 		HttpShutdownTimeout:  10,
 		RateLimit:            10000,
 		RateLimitBurst:       20000,
+		TrustedProxies:       []string{},
 		MaxUpdateSize:        200,
+		MaxUpdateFiles:       100000,
+		MaxUnpackedSize:      2048,
+		MaxUploadSize:        2048,
+		UploadChunkSize:      64,
+		UploadSessionTTL:     1440,
 		MaxVersionCount:      30,
 		MinVersionAge:        20,
 		TokensRead:           []string{},
 		TokensWrite:          []string{},
+		TokensAdmin:          []string{},
+		TokensRefresh:        30,
+		JwksReload:           10,
+		TlsMinVersion:        "1.2",
+		RateLimits:           map[string]RateLimit{},
+		ClientCerts:          map[string]ClientCert{},
+		Compression:          map[string]Compression{},
 	}
 
 	if configPath != "" {
@@ -62,9 +178,49 @@ func New(configPath string, logger lg.Logger) (*Config, error) {
 		}
 	}
 
+	// переменные окружения имеют приоритет над config.toml
+	if err := applyEnv(c); err != nil {
+		return nil, err
+	}
+
 	if c.DatabaseURL == "" {
 		return nil, fmt.Errorf("DATABASE_URL undefined")
 	}
 
+	if (len(c.TlsCertFile) == 0) != (len(c.TlsKeyFile) == 0) {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if len(c.TlsClientCAFile) > 0 && len(c.TlsCertFile) == 0 {
+		return nil, fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE")
+	}
+	// без проверки aud принимался бы любой JWT, подписанный тем же ключом для другого сервиса
+	if len(c.JwksPath) > 0 && len(c.JwtAudience) == 0 {
+		return nil, fmt.Errorf("JWT_JWKS_PATH requires JWT_AUDIENCE")
+	}
+
+	// архив больше распакованного размера все равно будет отклонен, но только после загрузки всех частей
+	if c.MaxUploadSize <= 0 {
+		return nil, fmt.Errorf("MAX_UPLOAD_SIZE must be positive")
+	}
+	if c.MaxUnpackedSize > 0 && c.MaxUploadSize > c.MaxUnpackedSize {
+		return nil, fmt.Errorf("MAX_UPLOAD_SIZE %d exceeds MAX_UNPACKED_SIZE %d", c.MaxUploadSize, c.MaxUnpackedSize)
+	}
+
+	if _, err := c.TrustedProxyNets(); err != nil {
+		return nil, err
+	}
+
+	for channel, cmp := range c.Compression {
+		if _, err := path.Match(channel, ""); err != nil {
+			return nil, fmt.Errorf("COMPRESSION: invalid channel pattern %s", channel)
+		}
+		if cmp.ZipLevel < 0 || cmp.ZipLevel > 9 {
+			return nil, fmt.Errorf("COMPRESSION %s: ZIP_LEVEL must be 0-9", channel)
+		}
+		if cmp.ZstdLevel < 0 || cmp.ZstdLevel > 22 {
+			return nil, fmt.Errorf("COMPRESSION %s: ZSTD_LEVEL must be 0-22", channel)
+		}
+	}
+
 	return c, nil
 }
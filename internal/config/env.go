@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// EnvPrefix префикс переменных окружения, переопределяющих параметры конфига
+const EnvPrefix = "UPDSRV_"
+
+// applyEnv переопределение параметров конфига переменными окружения.
+// Имя переменной - UPDSRV_ и имя параметра в config.toml: UPDSRV_DATABASE_URL.
+// Переменная с суффиксом _FILE содержит путь к файлу со значением: UPDSRV_DATABASE_URL_FILE=/run/secrets/db
+func applyEnv(c *Config) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("toml")
+		if len(name) == 0 || name == "-" {
+			continue
+		}
+
+		value, ok, err := lookupEnv(EnvPrefix + name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if err := setField(v.Field(i), name, value); err != nil {
+			return fmt.Errorf("%s%s: %v", EnvPrefix, name, err)
+		}
+	}
+
+	return nil
+}
+
+// значение переменной окружения или содержимое файла из переменной с суффиксом _FILE
+func lookupEnv(name string) (string, bool, error) {
+	value, ok := os.LookupEnv(name)
+	path, okFile := os.LookupEnv(name + "_FILE")
+
+	if ok && okFile {
+		return "", false, fmt.Errorf("%s and %s_FILE are both set", name, name)
+	}
+	if !okFile {
+		return value, ok, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %v", name, err)
+	}
+	// перевод строки в конце файла не является частью значения
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// setField запись значения переменной окружения в поле конфига
+func setField(field reflect.Value, name string, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+
+	case reflect.Slice:
+		// список через запятую или перевод строки (удобно для файлов с токенами)
+		list := []string{}
+		for _, s := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+			if s = strings.TrimSpace(s); len(s) > 0 {
+				list = append(list, s)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+
+	case reflect.Map:
+		// таблица в виде встроенной таблицы TOML: { add = { TOKEN = 5, IP = 20 } }
+		tmp := &Config{}
+		if _, err := toml.Decode(name+" = "+value, tmp); err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(tmp).Elem().FieldByIndex(fieldIndex(name)))
+
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+
+	return nil
+}
+
+// индекс поля Config по имени параметра в config.toml
+func fieldIndex(name string) []int {
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("toml") == name {
+			return t.Field(i).Index
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"reflect"
+)
+
+// параметры, которые применяются без перезапуска сервера по сигналу SIGHUP
+var liveOptions = map[string]bool{
+	"TOKENS_READ":       true,
+	"TOKENS_WRITE":      true,
+	"TOKENS_ADMIN":      true,
+	"TLS_CLIENT_CERTS":  true,
+	"RATE_LIMIT":        true,
+	"RATE_LIMIT_BURST":  true,
+	"RATE_LIMITS":       true,
+	"MAX_VERSION_COUNT": true,
+	"MIN_VERSION_AGE":   true,
+	"COMPRESSION":       true,
+}
+
+// RestartRequired параметры, которые изменились в новом конфиге, но применяются только после перезапуска
+func RestartRequired(old *Config, new *Config) []string {
+	var res []string
+
+	vOld := reflect.ValueOf(old).Elem()
+	vNew := reflect.ValueOf(new).Elem()
+	t := vOld.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("toml")
+		if liveOptions[name] {
+			continue
+		}
+		if !reflect.DeepEqual(vOld.Field(i).Interface(), vNew.Field(i).Interface()) {
+			res = append(res, name)
+		}
+	}
+
+	return res
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/n-r-w/lg"
 	"github.com/n-r-w/postgres"
 	"github.com/n-r-w/updsrv/internal/config"
+	"github.com/n-r-w/updsrv/internal/metrics"
 	"github.com/n-r-w/updsrv/internal/presenter"
 	"github.com/n-r-w/updsrv/internal/repo/psql"
 )
@@ -18,6 +19,7 @@ type Container struct {
 	Logger    lg.Logger
 	Config    *config.Config
 	DB        *postgres.Service
+	Metrics   *metrics.Service
 	Repo      *psql.Repo
 	Router    *httprouter.Service
 	Presenter *presenter.Service
@@ -27,8 +29,16 @@ type Container struct {
 func NewContainer(logger lg.Logger, config *config.Config, dbUrl postgres.Url, dbOptions []postgres.Option) (*Container, func(), error) {
 	panic(wire.Build(
 		postgres.New,
+		metrics.New,
 
 		wire.Bind(new(presenter.UpdateInterface), new(*psql.Repo)),
+		wire.Bind(new(presenter.StatInterface), new(*psql.Repo)),
+		wire.Bind(new(presenter.ReportInterface), new(*psql.Repo)),
+		wire.Bind(new(presenter.FleetInterface), new(*psql.Repo)),
+		wire.Bind(new(presenter.AuditInterface), new(*psql.Repo)),
+		wire.Bind(new(presenter.TokenInterface), new(*psql.Repo)),
+		wire.Bind(new(presenter.UploadInterface), new(*psql.Repo)),
+		wire.Bind(new(presenter.HealthInterface), new(*psql.Repo)),
 		psql.NewRepo,
 
 		wire.Bind(new(httprouter.Router), new(*httprouter.Service)),
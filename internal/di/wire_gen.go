@@ -11,6 +11,7 @@ import (
 	"github.com/n-r-w/lg"
 	"github.com/n-r-w/postgres"
 	"github.com/n-r-w/updsrv/internal/config"
+	"github.com/n-r-w/updsrv/internal/metrics"
 	"github.com/n-r-w/updsrv/internal/presenter"
 	"github.com/n-r-w/updsrv/internal/repo/psql"
 )
@@ -23,9 +24,10 @@ func NewContainer(logger lg.Logger, config2 *config.Config, dbUrl postgres.Url,
 	if err != nil {
 		return nil, nil, err
 	}
-	repo := psql.NewRepo(service, config2, logger)
+	metricsService := metrics.New(service)
+	repo := psql.NewRepo(service, config2, logger, metricsService)
 	httprouterService := httprouter.New(logger)
-	presenterService, err := presenter.New(httprouterService, repo, config2)
+	presenterService, err := presenter.New(httprouterService, repo, repo, repo, repo, repo, repo, repo, repo, metricsService, config2, logger)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -33,6 +35,7 @@ func NewContainer(logger lg.Logger, config2 *config.Config, dbUrl postgres.Url,
 		Logger:    logger,
 		Config:    config2,
 		DB:        service,
+		Metrics:   metricsService,
 		Repo:      repo,
 		Router:    httprouterService,
 		Presenter: presenterService,
@@ -47,6 +50,7 @@ type Container struct {
 	Logger    lg.Logger
 	Config    *config.Config
 	DB        *postgres.Service
+	Metrics   *metrics.Service
 	Repo      *psql.Repo
 	Router    *httprouter.Service
 	Presenter *presenter.Service
@@ -0,0 +1,13 @@
+package entity
+
+// ArchiveProblem нарушение в загруженном архиве. Name пустое для ограничений на архив целиком
+type ArchiveProblem struct {
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// ArchiveReport результат проверки загруженного архива. Возвращается клиенту с кодом 400
+type ArchiveReport struct {
+	Error    string           `json:"error"`
+	Problems []ArchiveProblem `json:"problems"`
+}
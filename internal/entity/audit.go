@@ -0,0 +1,34 @@
+package entity
+
+import "time"
+
+// Операции журнала аудита
+const (
+	AuditAdd         = "add"          // добавление обновления
+	AuditTokenCreate = "token_create" // создание токена доступа
+	AuditTokenRotate = "token_rotate" // смена значения токена доступа
+	AuditTokenRevoke = "token_revoke" // отзыв токена доступа
+)
+
+// AuditRecord запись журнала административных операций
+type AuditRecord struct {
+	ID        uint64            `json:"id,omitempty"`
+	Time      time.Time         `json:"time"`
+	Operation string            `json:"operation"`
+	Token     string            `json:"token"` // имя токена, а не его значение
+	IP        string            `json:"ip,omitempty"`
+	RealIP    string            `json:"realIP,omitempty"`
+	Channel   string            `json:"channel,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+	Status    int               `json:"status"` // http код ответа
+	Result    string            `json:"result,omitempty"`
+}
+
+// AuditFilter фильтр журнала аудита
+type AuditFilter struct {
+	From      time.Time
+	To        time.Time
+	Channel   string
+	Operation string
+	Limit     int
+}
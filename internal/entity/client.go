@@ -4,6 +4,8 @@ import "context"
 
 // ClientInfo информация о клиенте
 type ClientInfo struct {
+	ID       string // идентификатор клиента для учета установленных версий
+	Token    string // имя токена доступа
 	IP       string
 	RealIP   string
 	LocalIP  string
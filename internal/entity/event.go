@@ -0,0 +1,41 @@
+package entity
+
+import "time"
+
+// Виды событий выдачи обновлений
+const (
+	EventCheck = "check" // проверка наличия обновления
+	EventCache = "cache" // выдача дельты из кэша
+	EventDiff  = "diff"  // вычисление дельты
+	EventFull  = "full"  // выдача полного пакета, в том числе из кэша
+)
+
+// Event событие выдачи обновления
+type Event struct {
+	Event    string
+	Channel  string
+	From     Version // версия клиента
+	To       Version // версия обновления
+	Bytes    int
+	Duration time.Duration
+}
+
+// StatFilter фильтр статистики
+type StatFilter struct {
+	Channel string
+	From    time.Time
+	To      time.Time
+}
+
+// Stat статистика по каналу, версии и дню
+type Stat struct {
+	Channel     string  `json:"channel"`
+	Version     Version `json:"version"`
+	Day         string  `json:"day"`
+	Checks      int     `json:"checks"`
+	FromCache   int     `json:"fromCache"`
+	Diffs       int     `json:"diffs"`
+	Full        int     `json:"full"`
+	Bytes       uint64  `json:"bytes"`
+	AvgDuration int     `json:"avgDuration"` // средняя длительность выдачи обновления в миллисекундах
+}
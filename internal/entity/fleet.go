@@ -0,0 +1,40 @@
+package entity
+
+import "time"
+
+// FleetClient последнее известное состояние клиента
+type FleetClient struct {
+	ID        string    `json:"id"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Channel   string    `json:"channel"`
+	Version   Version   `json:"version"`
+	IP        string    `json:"ip,omitempty"`
+	RealIP    string    `json:"realIP,omitempty"`
+	LocalIP   string    `json:"localIP,omitempty"`
+	AppLogin  string    `json:"appLogin,omitempty"`
+	OsLogin   string    `json:"osLogin,omitempty"`
+}
+
+// FleetFilter фильтр клиентов
+type FleetFilter struct {
+	Channel      string
+	Version      Version // начало версии, например 4.1 для всех 4.1.x
+	VersionParts int     // сколько компонент версии учитывать. 0 - любая версия
+	ActiveDays   int     // обращались за последние N дней. 0 - без ограничений
+	InactiveDays int     // не обращались N дней. 0 - без ограничений
+	Limit        int
+}
+
+// FleetVersion количество клиентов на версии
+type FleetVersion struct {
+	Channel string  `json:"channel"`
+	Version Version `json:"version"`
+	Clients int     `json:"clients"`
+}
+
+// FleetList список клиентов
+type FleetList struct {
+	Total   int           `json:"total"` // общее количество без учета limit
+	Clients []FleetClient `json:"clients"`
+}
@@ -0,0 +1,37 @@
+package entity
+
+// ManifestFileName имя файла манифеста в архиве обновления
+const ManifestFileName = ".update_manifest.json"
+
+// DiffInfoFileName имя файла со списком изменений в архиве обновления (формат до манифеста)
+const DiffInfoFileName = ".update_file_info.txt"
+
+// ManifestFormat версия формата манифеста. Увеличивается при несовместимых изменениях
+const ManifestFormat = 1
+
+// Manifest описание содержимого архива обновления
+type Manifest struct {
+	Format int             `json:"format"`
+	Full   bool            `json:"full"` // полное содержимое версии, а не разница между версиями
+	From   ManifestVersion `json:"from"` // для полной версии не заполняется
+	To     ManifestVersion `json:"to"`
+	Files  []ManifestFile  `json:"files"`
+}
+
+// ManifestVersion канал и версия
+type ManifestVersion struct {
+	Channel string  `json:"channel"`
+	Version Version `json:"version"`
+}
+
+// ManifestFile файл обновления
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // new, modified, removed
+	Sha256 string `json:"sha256"` // для удаленных файлов - контрольная сумма прежней версии
+	Size   int64  `json:"size"`   // для удаленных файлов 0
+	Mode   uint32 `json:"mode"`   // права доступа unix
+
+	ModTime    int64  `json:"modTime,omitempty"`    // время изменения файла, unix time
+	LinkTarget string `json:"linkTarget,omitempty"` // для символической ссылки - куда она указывает
+}
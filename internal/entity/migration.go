@@ -0,0 +1,10 @@
+package entity
+
+import "time"
+
+// MigrationStatus состояние миграции схемы БД
+type MigrationStatus struct {
+	Version   string    `json:"version"`
+	Applied   bool      `json:"applied"`
+	ApplyTime time.Time `json:"applyTime,omitempty"`
+}
@@ -0,0 +1,20 @@
+package entity
+
+// Форматы архива обновления, выдаваемого клиенту
+const (
+	PackageZip     = "zip"     // zip, по умолчанию
+	PackageTarZstd = "tar.zst" // tar, сжатый zstd
+)
+
+// PackageContentType тип содержимого архива обновления для http ответа
+func PackageContentType(format string) string {
+	if format == PackageTarZstd {
+		return "application/zstd"
+	}
+	return "application/zip"
+}
+
+// ValidPackageFormat проверка формата архива обновления
+func ValidPackageFormat(format string) bool {
+	return format == PackageZip || format == PackageTarZstd
+}
@@ -0,0 +1,25 @@
+package entity
+
+// PublishPreview результат пробной публикации (dryRun): архив проверен, в БД ничего не записано
+type PublishPreview struct {
+	Channel   string  `json:"channel"`
+	Version   Version `json:"version"`
+	FileCount int     `json:"fileCount"`
+	TotalSize int64   `json:"totalSize"` // суммарный размер файлов новой версии в байтах
+	// Conflict уже существующая версия канала с тем же номером. Публикация с таким номером будет отклонена
+	Conflict *UpdateInfo `json:"conflict,omitempty"`
+	// Latest последняя включенная версия канала, относительно которой вычислен Diff. nil, если включенных версий нет
+	Latest *UpdateInfo `json:"latest,omitempty"`
+	Diff   PreviewDiff `json:"diff"`
+}
+
+// PreviewDiff изменения относительно последней версии. Размер измененного файла - новый
+type PreviewDiff struct {
+	Added        int        `json:"added"`
+	AddedSize    int64      `json:"addedSize"`
+	Removed      int        `json:"removed"`
+	RemovedSize  int64      `json:"removedSize"`
+	Modified     int        `json:"modified"`
+	ModifiedSize int64      `json:"modifiedSize"`
+	Files        []FileInfo `json:"files"` // измененные файлы со статусом new, removed или modified
+}
@@ -0,0 +1,20 @@
+package entity
+
+// PublishReceipt результат публикации версии, возвращается вместо пустого ответа 201
+type PublishReceipt struct {
+	ID                uint64  `json:"id"`
+	Channel           string  `json:"channel"`
+	Version           Version `json:"version"`
+	NormalizedVersion string  `json:"normalizedVersion"` // версия из 4 компонент, например 4.1.0.0
+	FileCount         int     `json:"fileCount"`
+	TotalSize         int64   `json:"totalSize"` // суммарный размер файлов в байтах
+	// UniqueContentSize суммарный размер различного содержимого: файлы с одинаковой контрольной суммой учитываются один раз.
+	// Только оценка, в хранилище такие файлы сохраняются отдельно
+	UniqueContentSize int64 `json:"uniqueContentSize"`
+	// ManifestHash sha256 списка файлов версии (имя, контрольная сумма, права доступа, ссылка), не зависит от порядка файлов
+	ManifestHash string `json:"manifestHash"`
+	// Deleted версии канала, удаленные по MAX_VERSION_COUNT и MIN_VERSION_AGE в той же транзакции
+	Deleted []Version `json:"deleted"`
+	// WarmupScheduled запущена ли в фоне подготовка разницы с предыдущей последней версией
+	WarmupScheduled bool `json:"warmupScheduled"`
+}
@@ -0,0 +1,25 @@
+package entity
+
+// InstallReport отчет клиента о результате установки обновления
+type InstallReport struct {
+	Channel  string  `json:"channel,omitempty"`
+	From     Version `json:"from,omitempty"`
+	To       Version `json:"to,omitempty"`
+	Success  bool    `json:"success"`
+	Error    string  `json:"error,omitempty"`
+	Duration int     `json:"duration,omitempty"` // длительность установки в миллисекундах
+	LocalIP  string  `json:"localIP,omitempty"`
+	AppLogin string  `json:"appLogin,omitempty"`
+	OsLogin  string  `json:"osLogin,omitempty"`
+}
+
+// InstallStat статистика установки версии
+type InstallStat struct {
+	Channel     string  `json:"channel"`
+	Version     Version `json:"version"`
+	Total       int     `json:"total"`
+	Failed      int     `json:"failed"`
+	FailureRate float64 `json:"failureRate"` // доля неудачных установок от 0 до 1
+	AvgDuration int     `json:"avgDuration"` // средняя длительность успешной установки в миллисекундах
+	LastError   string  `json:"lastError,omitempty"`
+}
@@ -0,0 +1,88 @@
+package entity
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// Права токенов доступа
+const (
+	ScopeRead  = "read"  // получение обновлений
+	ScopeWrite = "write" // добавление обновлений
+	ScopeAdmin = "admin" // административные операции
+)
+
+// Token токен доступа
+type Token struct {
+	ID         uint64    `json:"id,omitempty"`
+	Name       string    `json:"name"`
+	Scopes     []string  `json:"scopes"`
+	Channels   []string  `json:"channels,omitempty"`   // разрешенные каналы или шаблоны вида HRFILE_*. Пустой - все каналы
+	ExpireTime time.Time `json:"expireTime,omitempty"` // нулевое значение - бессрочный
+	Revoked    bool      `json:"revoked"`
+	CreateTime time.Time `json:"createTime,omitempty"`
+	RotateTime time.Time `json:"rotateTime,omitempty"`
+	SecretHash string    `json:"-"` // sha256 от значения токена
+}
+
+// HasScope проверка наличия права
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowChannel проверка доступа к каналу
+func (t *Token) AllowChannel(channel string) bool {
+	if len(t.Channels) == 0 {
+		return true
+	}
+	for _, pattern := range t.Channels {
+		if ok, err := path.Match(pattern, channel); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidChannelPattern проверка корректности шаблона канала
+func ValidChannelPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return len(pattern) > 0 && err == nil
+}
+
+// Valid токен не отозван и не просрочен
+func (t *Token) Valid(now time.Time) bool {
+	return !t.Revoked && (t.ExpireTime.IsZero() || now.Before(t.ExpireTime))
+}
+
+// ValidScope проверка корректности названия права
+func ValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeWrite || scope == ScopeAdmin
+}
+
+// ListString список через запятую для хранения в БД (права, каналы)
+func ListString(list []string) string {
+	return strings.Join(list, ",")
+}
+
+// ParseList список из строки через запятую
+func ParseList(s string) []string {
+	res := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// TokenSecret созданный или перевыпущенный токен вместе со значением. Значение возвращается только один раз
+type TokenSecret struct {
+	Token
+	Secret string `json:"secret"`
+}
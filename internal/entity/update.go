@@ -3,6 +3,8 @@ package entity
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,6 +20,29 @@ func (v *Version) String() string {
 	return fmt.Sprintf("%d.%d.%d.%d", v.Major, v.Minor, v.Patch, v.Revision)
 }
 
+// ParseVersion разбор версии вида 4.1.2.9. Возвращает количество указанных компонент версии
+func ParseVersion(version string) (Version, int, error) {
+	var res Version
+
+	v := strings.Split(version, ".")
+	if len(v) == 0 || len(v) > 4 {
+		return Version{}, 0, fmt.Errorf("invalid version %s", version)
+	}
+
+	for i, dst := range []*int{&res.Major, &res.Minor, &res.Patch, &res.Revision} {
+		if i >= len(v) {
+			break
+		}
+		n, err := strconv.Atoi(v[i])
+		if err != nil {
+			return Version{}, 0, fmt.Errorf("invalid version %s", version)
+		}
+		*dst = n
+	}
+
+	return res, len(v), nil
+}
+
 // Состояние файла при выдаче дифа
 const (
 	FileCreated  = "new"      // новый файл
@@ -27,11 +52,24 @@ const (
 
 // FileInfo информация о файле
 type FileInfo struct {
-	Name     string `json:"name,omitempty"`
-	Checksum string `json:"checksum,omitempty"`
-	Status   string `json:"status,omitempty"`
-	Data     []byte `json:"-"`
-	DataID   uint32 `json:"oid,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Checksum   string    `json:"checksum,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	Size       int64     `json:"size,omitempty"`       // размер в байтах
+	Mode       uint32    `json:"mode,omitempty"`       // права доступа unix
+	ModTime    time.Time `json:"modTime,omitempty"`    // время изменения файла. Нулевое - неизвестно
+	LinkTarget string    `json:"linkTarget,omitempty"` // для символической ссылки - куда она указывает. Data содержит то же самое
+	Data       []byte    `json:"-"`
+	DataID     uint32    `json:"oid,omitempty"`
+}
+
+// DefaultFileMode права доступа файла, если в архиве они не указаны
+const DefaultFileMode = 0644
+
+// SameContent одинаковы ли файлы с точки зрения клиента: содержимое, права доступа и ссылка.
+// Время изменения не учитывается, т.к. оно меняется при каждой сборке
+func (f *FileInfo) SameContent(other *FileInfo) bool {
+	return f.Checksum == other.Checksum && f.Mode == other.Mode && f.LinkTarget == other.LinkTarget
 }
 
 // UpdateInfo информация об обновлении
@@ -48,9 +86,11 @@ type UpdateInfo struct {
 
 // CheckRequest запрос информации об обновлении
 type CheckRequest struct {
+	ClientID string  `json:"clientId,omitempty"` // необязательный постоянный идентификатор клиента
 	Channel  string  `json:"channel,omitempty"`
 	Version  Version `json:"version,omitempty"`
 	LocalIP  string  `json:"localIP,omitempty"`
 	AppLogin string  `json:"appLogin,omitempty"`
 	OsLogin  string  `json:"osLogin,omitempty"`
+	Format   string  `json:"format,omitempty"` // формат архива обновления: zip, tar.zst. Имеет приоритет над заголовком Accept
 }
@@ -0,0 +1,42 @@
+package entity
+
+import "time"
+
+// UploadSession сессия загрузки обновления частями. Параметры версии задаются при создании сессии
+type UploadSession struct {
+	ID         string        `json:"id"`
+	Token      string        `json:"-"` // имя токена, создавшего сессию. Сессия доступна только ему
+	Channel    string        `json:"channel"`
+	Version    Version       `json:"version"`
+	Info       string        `json:"info,omitempty"`
+	Enabled    bool          `json:"enabled"`
+	BuildTime  time.Time     `json:"buildTime,omitempty"` // нулевое - время завершения загрузки
+	Size       int64         `json:"size,omitempty"`      // ожидаемый размер архива. 0 - не проверяется
+	Checksum   string        `json:"checksum,omitempty"`  // ожидаемый sha256 архива. Пустой - не проверяется
+	Received   int64         `json:"received"`            // сколько байт получено подряд с начала архива
+	NextChunk  int           `json:"nextChunk"`           // номер первой отсутствующей части
+	Chunks     []UploadChunk `json:"chunks,omitempty"`
+	CreateTime time.Time     `json:"createTime"`
+	ExpireTime time.Time     `json:"expireTime"` // продлевается при получении каждой части
+}
+
+// UploadChunk часть архива в сессии загрузки. Нумерация с 0
+type UploadChunk struct {
+	Number   int    `json:"number"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"` // sha256
+	DataID   uint32 `json:"-"`
+}
+
+// UpdateProgress вычисление Received и NextChunk по частям, отсортированным по номеру
+func (s *UploadSession) UpdateProgress() {
+	s.Received = 0
+	s.NextChunk = 0
+	for _, c := range s.Chunks {
+		if c.Number != s.NextChunk {
+			break
+		}
+		s.Received += c.Size
+		s.NextChunk++
+	}
+}
@@ -0,0 +1,101 @@
+// Package metrics Метрики сервера в формате Prometheus
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/n-r-w/postgres"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// интервалы гистограмм длительности в секундах
+var (
+	requestBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	diffBuckets    = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+)
+
+// Service метрики сервера
+type Service struct {
+	registry *prometheus.Registry
+
+	HttpRequests        *prometheus.CounterVec   // количество запросов по маршруту, методу и коду ответа
+	HttpDuration        *prometheus.HistogramVec // длительность обработки запросов по маршруту
+	CacheRequests       *prometheus.CounterVec   // обращения к кэшу дифов: hit, miss
+	DiffDuration        *prometheus.HistogramVec // длительность вычисления дифа
+	BytesServed         *prometheus.CounterVec   // объем выданных обновлений
+	DiffWaiters         prometheus.Gauge         // количество запросов, ожидающих готовности дифа
+	RateLimitRejections *prometheus.CounterVec   // отказы по ограничению частоты запросов
+}
+
+func New(db *postgres.Service) *Service {
+	s := &Service{
+		registry: prometheus.NewRegistry(),
+		HttpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "updsrv_http_requests_total",
+			Help: "Total number of HTTP requests.",
+		}, []string{"route", "method", "status"}),
+		HttpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "updsrv_http_request_duration_seconds",
+			Help:    "HTTP request latencies in seconds.",
+			Buckets: requestBuckets,
+		}, []string{"route"}),
+		CacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "updsrv_cache_requests_total",
+			Help: "Diff cache lookups by result.",
+		}, []string{"result"}),
+		DiffDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "updsrv_diff_build_duration_seconds",
+			Help:    "Diff build durations in seconds.",
+			Buckets: diffBuckets,
+		}, []string{"kind"}),
+		BytesServed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "updsrv_bytes_served_total",
+			Help: "Total size of served update packages in bytes.",
+		}, []string{"channel"}),
+		DiffWaiters: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "updsrv_diff_waiters",
+			Help: "Number of requests waiting for a diff that is being built.",
+		}),
+		RateLimitRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "updsrv_rate_limit_rejections_total",
+			Help: "Requests rejected by rate limits.",
+		}, []string{"endpoint", "limit"}),
+	}
+
+	s.registry.MustRegister(
+		s.HttpRequests, s.HttpDuration, s.CacheRequests, s.DiffDuration, s.BytesServed, s.DiffWaiters, s.RateLimitRejections,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	// состояние пула соединений с БД
+	if db != nil && db.Pool != nil {
+		s.registry.MustRegister(
+			poolGauge("updsrv_db_pool_total_conns", "Total number of DB connections in the pool.",
+				func() float64 { return float64(db.Pool.Stat().TotalConns()) }),
+			poolGauge("updsrv_db_pool_idle_conns", "Number of idle DB connections in the pool.",
+				func() float64 { return float64(db.Pool.Stat().IdleConns()) }),
+			poolGauge("updsrv_db_pool_acquired_conns", "Number of acquired DB connections in the pool.",
+				func() float64 { return float64(db.Pool.Stat().AcquiredConns()) }),
+			poolGauge("updsrv_db_pool_max_conns", "Maximum size of the DB connection pool.",
+				func() float64 { return float64(db.Pool.Stat().MaxConns()) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name: "updsrv_db_pool_acquire_count",
+				Help: "Cumulative count of successful DB connection acquires.",
+			}, func() float64 { return float64(db.Pool.Stat().AcquireCount()) }),
+		)
+	}
+
+	return s
+}
+
+func poolGauge(name string, help string, value func() float64) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, value)
+}
+
+// Handler выдача метрик в формате Prometheus
+func (s *Service) Handler() http.Handler {
+	return promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,185 @@
+package presenter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// archiveEntry элемент загруженного архива до распаковки
+type archiveEntry struct {
+	Name        string // имя в архиве как есть
+	Size        uint64 // размер после распаковки, заявленный в архиве
+	IsDir       bool
+	IsLink      bool   // символическая ссылка
+	LinkTarget  string // куда указывает ссылка, если известно из заголовка
+	Mode        uint32 // права доступа unix
+	ModTime     time.Time
+	Unsupported string // причина, по которой элемент не может быть загружен
+}
+
+// archiveLimits ограничения на загружаемый архив. Нулевое значение - без ограничения
+type archiveLimits struct {
+	MaxEntries      int
+	MaxUnpackedSize uint64
+}
+
+func (p *Service) archiveLimits() archiveLimits {
+	return archiveLimits{
+		MaxEntries:      p.config.MaxUpdateFiles,
+		MaxUnpackedSize: uint64(p.config.MaxUnpackedSize) << 20,
+	}
+}
+
+// reservedEntryNames служебные файлы пакета обновления в корне архива, в нижнем регистре
+var reservedEntryNames = map[string]bool{
+	entity.ManifestFileName: true,
+	entity.DiffInfoFileName: true,
+}
+
+/*
+	validateArchive проверка элементов архива до распаковки.
+
+Возвращает нормализованные имена в том же порядке, что и entries (для каталогов - пустые), и список всех нарушений
+*/
+func validateArchive(entries []archiveEntry, limits archiveLimits) ([]string, []entity.ArchiveProblem) {
+	var problems []entity.ArchiveProblem
+
+	if limits.MaxEntries > 0 && len(entries) > limits.MaxEntries {
+		problems = append(problems, entity.ArchiveProblem{
+			Reason: fmt.Sprintf("too many entries: %d, max %d", len(entries), limits.MaxEntries),
+		})
+	}
+
+	names := make([]string, len(entries))
+	exact := map[string]string{}  // нормализованное имя -> имя в архиве
+	folded := map[string]string{} // имя в нижнем регистре -> нормализованное имя
+	dirs := map[string]string{}   // каталоги, в которых есть файлы, в нижнем регистре -> файл
+	var total uint64
+
+	for i, e := range entries {
+		// каталоги не распаковываются, поэтому их имена не проверяются. В том числе "./" от tar -C dir .
+		if e.IsDir {
+			continue
+		}
+
+		name, reason := normalizeEntryName(e.Name)
+		if len(reason) == 0 {
+			reason = e.Unsupported
+		}
+		// служебные файлы сервер добавляет в пакет сам, загруженные подменили бы манифест
+		if len(reason) == 0 && reservedEntryNames[strings.ToLower(name)] {
+			reason = "reserved name"
+		}
+		if len(reason) > 0 {
+			problems = append(problems, entity.ArchiveProblem{Name: e.Name, Reason: reason})
+			continue
+		}
+
+		// заявленные размеры берутся из архива и не должны переполнять сумму
+		if total+e.Size < total {
+			total = math.MaxUint64
+		} else {
+			total += e.Size
+		}
+
+		if prev, ok := exact[name]; ok {
+			problems = append(problems, entity.ArchiveProblem{Name: e.Name, Reason: fmt.Sprintf("duplicate of %s", prev)})
+			continue
+		}
+		exact[name] = e.Name
+
+		// в windows имена файлов не зависят от регистра
+		key := strings.ToLower(name)
+		if prev, ok := folded[key]; ok {
+			problems = append(problems, entity.ArchiveProblem{Name: e.Name, Reason: fmt.Sprintf("case-insensitive collision with %s", prev)})
+			continue
+		}
+		folded[key] = name
+
+		for dir := path.Dir(key); dir != "."; dir = path.Dir(dir) {
+			if _, ok := dirs[dir]; !ok {
+				dirs[dir] = name
+			}
+		}
+
+		names[i] = name
+	}
+
+	// файл не может одновременно быть каталогом
+	for i, name := range names {
+		if file, ok := dirs[strings.ToLower(name)]; ok && len(name) > 0 {
+			problems = append(problems, entity.ArchiveProblem{Name: entries[i].Name, Reason: fmt.Sprintf("file conflicts with directory of %s", file)})
+		}
+	}
+
+	if limits.MaxUnpackedSize > 0 && total > limits.MaxUnpackedSize {
+		problems = append(problems, entity.ArchiveProblem{
+			Reason: fmt.Sprintf("unpacked size too large: %d bytes, max %d", total, limits.MaxUnpackedSize),
+		})
+	}
+
+	return names, problems
+}
+
+// normalizeEntryName приведение имени элемента архива к виду dir/file. Возвращает причину, если имя недопустимо
+func normalizeEntryName(name string) (string, string) {
+	name = strings.TrimSuffix(name, "/")
+
+	switch {
+	case len(name) == 0:
+		return "", "empty name"
+	case strings.Contains(name, "\\"):
+		return "", "backslash in name"
+	case strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':'):
+		return "", "absolute path"
+	case strings.ContainsRune(name, ':') || strings.IndexFunc(name, func(r rune) bool { return r < 0x20 }) >= 0:
+		return "", "invalid character in name"
+	}
+
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", "path traversal"
+		}
+	}
+
+	name = path.Clean(name)
+	if name == "." {
+		return "", "empty name"
+	}
+
+	return name, ""
+}
+
+// checkEntryLink символическая ссылка должна указывать внутрь архива
+func checkEntryLink(name string, target string) string {
+	switch {
+	case len(target) == 0:
+		return "empty symlink target"
+	case strings.Contains(target, "\\"):
+		return "backslash in symlink target"
+	case strings.HasPrefix(target, "/") || strings.Contains(target, ":"):
+		return "absolute symlink target"
+	}
+
+	if full := path.Join(path.Dir(name), target); full == ".." || strings.HasPrefix(full, "../") {
+		return "symlink target outside archive"
+	}
+	return ""
+}
+
+// respondArchiveProblems ответ 400 со списком всех нарушений в архиве
+func (p *Service) respondArchiveProblems(w http.ResponseWriter, problems []entity.ArchiveProblem) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(entity.ArchiveReport{
+		Error:    "invalid archive",
+		Problems: problems,
+	})
+}
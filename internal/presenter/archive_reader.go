@@ -0,0 +1,379 @@
+package presenter
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/n-r-w/tools"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// Форматы загружаемых архивов
+const (
+	formatZip     = "zip"
+	formatTar     = "tar"
+	formatTarGzip = "tar.gz"
+	formatTarZstd = "tar.zst"
+	formatTree    = "tree" // дерево каталогов: каждый файл отдельным полем формы
+)
+
+// максимальный размер окна zstd, как у утилиты zstd по умолчанию. Ограничивает память на распаковку
+const zstdMaxWindow = 128 << 20
+
+var errUnpackedTooLarge = errors.New("unpacked size too large")
+
+// archiveReader загруженный архив независимо от формата
+type archiveReader interface {
+	// Format формат архива
+	Format() string
+	// Entries заголовки элементов архива без распаковки содержимого
+	Entries() ([]archiveEntry, error)
+	// Walk последовательный обход содержимого элементов. Номер элемента соответствует Entries, каталоги не передаются
+	Walk(fn func(i int, data io.Reader) error) error
+}
+
+// detectArchive определение формата архива по содержимому
+func detectArchive(file io.ReaderAt, size int64, limits archiveLimits) (archiveReader, error) {
+	head := make([]byte, 512)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		zr, err := zip.NewReader(file, size)
+		if err != nil {
+			return nil, err
+		}
+		return &zipArchive{zr: zr}, nil
+
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return newTarArchive(formatTarGzip, file, size, limits, func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		}), nil
+
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return newTarArchive(formatTarZstd, file, size, limits, func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true),
+				zstd.WithDecoderMaxWindow(zstdMaxWindow))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		}), nil
+
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return newTarArchive(formatTar, file, size, limits, func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(r), nil
+		}), nil
+	}
+
+	return nil, fmt.Errorf("unknown archive format, supported: zip, tar, tar.gz, tar.zst")
+}
+
+// zipArchive архив zip
+type zipArchive struct {
+	zr *zip.Reader
+}
+
+func (a *zipArchive) Format() string {
+	return formatZip
+}
+
+func (a *zipArchive) Entries() ([]archiveEntry, error) {
+	entries := make([]archiveEntry, len(a.zr.File))
+	for i, f := range a.zr.File {
+		entries[i] = archiveEntry{
+			Name:    f.Name,
+			Size:    f.UncompressedSize64,
+			IsDir:   f.FileInfo().IsDir(),
+			IsLink:  f.Mode()&os.ModeSymlink != 0,
+			Mode:    zipFileMode(f),
+			ModTime: f.Modified,
+		}
+	}
+	return entries, nil
+}
+
+func (a *zipArchive) Walk(fn func(i int, data io.Reader) error) error {
+	for i, f := range a.zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		// размер уже проверен, но заголовку архива не доверяем
+		err = fn(i, io.LimitReader(r, int64(f.UncompressedSize64)))
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// права доступа файла из архива. Архивы, созданные не в unix, прав доступа не содержат
+func zipFileMode(f *zip.File) uint32 {
+	const creatorUnix = 3
+	if f.CreatorVersion>>8 != creatorUnix || f.Mode().Perm() == 0 {
+		return entity.DefaultFileMode
+	}
+	return uint32(f.Mode().Perm())
+}
+
+// tarArchive архив tar, возможно сжатый. Читается потоком, поэтому каждый обход распаковывает архив заново
+type tarArchive struct {
+	format     string
+	file       io.ReaderAt
+	size       int64
+	maxRead    int64 // ограничение на объем распакованного потока. 0 - без ограничения
+	decompress func(r io.Reader) (io.ReadCloser, error)
+}
+
+func newTarArchive(format string, file io.ReaderAt, size int64, limits archiveLimits,
+	decompress func(r io.Reader) (io.ReadCloser, error)) *tarArchive {
+	a := &tarArchive{
+		format:     format,
+		file:       file,
+		size:       size,
+		decompress: decompress,
+	}
+
+	// защита от архивов-бомб: кроме содержимого файлов в tar есть заголовки и выравнивание блоков
+	if limits.MaxUnpackedSize > 0 && limits.MaxEntries > 0 {
+		a.maxRead = int64(limits.MaxUnpackedSize) + int64(limits.MaxEntries+2)*3*512
+	}
+
+	return a
+}
+
+func (a *tarArchive) Format() string {
+	return a.format
+}
+
+func (a *tarArchive) Entries() ([]archiveEntry, error) {
+	var entries []archiveEntry
+	err := a.scan(func(h *tar.Header, tr *tar.Reader) error {
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			return nil
+		}
+
+		e := archiveEntry{
+			Name:    h.Name,
+			ModTime: h.ModTime,
+			Mode:    uint32(h.Mode) & 0777,
+		}
+		if e.Mode == 0 {
+			e.Mode = entity.DefaultFileMode
+		}
+
+		switch h.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			e.Size = uint64(h.Size)
+		case tar.TypeDir:
+			e.IsDir = true
+		case tar.TypeSymlink:
+			e.IsLink = true
+			e.LinkTarget = h.Linkname
+			e.Size = uint64(len(h.Linkname))
+		case tar.TypeLink:
+			e.Unsupported = "hard links are not supported"
+		default:
+			e.Unsupported = fmt.Sprintf("unsupported entry type %q", h.Typeflag)
+		}
+
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+func (a *tarArchive) Walk(fn func(i int, data io.Reader) error) error {
+	i := -1
+	return a.scan(func(h *tar.Header, tr *tar.Reader) error {
+		// общий заголовок pax (git archive) не попадает в Entries
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			return nil
+		}
+		i++
+		switch h.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			return fn(i, tr)
+		case tar.TypeSymlink:
+			// содержимое символической ссылки - путь, на который она указывает
+			return fn(i, bytes.NewReader([]byte(h.Linkname)))
+		}
+		return nil
+	})
+}
+
+// scan обход заголовков tar с распаковкой с начала файла
+func (a *tarArchive) scan(fn func(h *tar.Header, tr *tar.Reader) error) error {
+	r, err := a.decompress(io.NewSectionReader(a.file, 0, a.size))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var src io.Reader = r
+	if a.maxRead > 0 {
+		src = &limitedReader{r: r, left: a.maxRead}
+	}
+
+	tr := tar.NewReader(src)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(h, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// limitedReader в отличие от io.LimitReader при превышении лимита возвращает ошибку, а не конец файла
+type limitedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left <= 0 {
+		return 0, errUnpackedTooLarge
+	}
+	if int64(len(p)) > l.left {
+		p = p[:l.left]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	return n, err
+}
+
+// treeArchive дерево каталогов, загруженное отдельными файлами формы. Пути файлов в поле paths в том же порядке
+type treeArchive struct {
+	files []*multipart.FileHeader
+	paths []string
+}
+
+func newTreeArchive(files []*multipart.FileHeader, paths []string) (*treeArchive, error) {
+	if len(paths) > 0 && len(paths) != len(files) {
+		return nil, fmt.Errorf("paths count %d does not match files count %d", len(paths), len(files))
+	}
+	return &treeArchive{files: files, paths: paths}, nil
+}
+
+func (a *treeArchive) Format() string {
+	return formatTree
+}
+
+func (a *treeArchive) Entries() ([]archiveEntry, error) {
+	entries := make([]archiveEntry, len(a.files))
+	for i, f := range a.files {
+		name := f.Filename
+		if len(a.paths) > 0 {
+			name = a.paths[i]
+		}
+		entries[i] = archiveEntry{
+			Name: name,
+			Size: uint64(f.Size),
+			Mode: entity.DefaultFileMode,
+		}
+	}
+	return entries, nil
+}
+
+func (a *treeArchive) Walk(fn func(i int, data io.Reader) error) error {
+	for i, f := range a.files {
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(i, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readArchive распаковка проверенного архива. names - нормализованные имена из validateArchive
+func readArchive(a archiveReader, entries []archiveEntry, names []string) ([]entity.FileInfo, []entity.ArchiveProblem, error) {
+	var files []entity.FileInfo
+	var problems []entity.ArchiveProblem
+
+	err := a.Walk(func(i int, data io.Reader) error {
+		e := entries[i]
+
+		fi := entity.FileInfo{
+			Name:    names[i],
+			Mode:    e.Mode,
+			ModTime: e.ModTime,
+		}
+
+		var err error
+		if fi.Data, err = io.ReadAll(data); err != nil {
+			return err
+		}
+		if uint64(len(fi.Data)) != e.Size {
+			return fmt.Errorf("%s: size mismatch", e.Name)
+		}
+		fi.Size = int64(len(fi.Data))
+
+		if e.IsLink {
+			fi.LinkTarget = string(fi.Data)
+			if reason := checkEntryLink(fi.Name, fi.LinkTarget); len(reason) > 0 {
+				problems = append(problems, entity.ArchiveProblem{Name: e.Name, Reason: reason})
+				return nil
+			}
+		}
+
+		if fi.Checksum, err = tools.Sha256sum(fi.Data); err != nil {
+			return err
+		}
+
+		files = append(files, fi)
+		return nil
+	})
+
+	return files, problems, err
+}
+
+// uploadedArchive архив из формы запроса. Функция закрытия вызывается после обработки архива
+func uploadedArchive(r *http.Request, limits archiveLimits) (archiveReader, func(), error) {
+	if r.MultipartForm != nil && len(r.MultipartForm.File["files"]) > 0 {
+		archive, err := newTreeArchive(r.MultipartForm.File["files"], r.MultipartForm.Value["paths"])
+		return archive, func() {}, err
+	}
+
+	file, header, err := r.FormFile("update")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	archive, err := detectArchive(file, header.Size, limits)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return archive, func() { file.Close() }, nil
+}
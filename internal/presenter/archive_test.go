@@ -0,0 +1,263 @@
+package presenter
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNormalizeEntryName(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   string
+		reason string
+	}{
+		{"bin/app", "bin/app", ""},
+		{"bin/", "bin", ""},
+		{"./bin/app", "bin/app", ""},
+		{"bin//app", "bin/app", ""},
+		{"", "", "empty name"},
+		{"/", "", "empty name"},
+		{"../etc/passwd", "", "path traversal"},
+		{"bin/../../etc", "", "path traversal"},
+		{"bin/..", "", "path traversal"},
+		{"/etc/passwd", "", "absolute path"},
+		{"C:/Windows/app.exe", "", "absolute path"},
+		{"c:app.exe", "", "absolute path"},
+		{"bin\\app.exe", "", "backslash in name"},
+		{"..\\evil", "", "backslash in name"},
+		{"bin/app:stream", "", "invalid character in name"},
+		{"bin/a\nb", "", "invalid character in name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := normalizeEntryName(tt.name)
+			if got != tt.want || reason != tt.reason {
+				t.Fatalf("normalizeEntryName(%q) = %q, %q, want %q, %q", tt.name, got, reason, tt.want, tt.reason)
+			}
+		})
+	}
+}
+
+func TestCheckEntryLink(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		reason string
+	}{
+		{"lib/libx.so", "libx.so.1", ""},
+		{"bin/app", "../lib/app", ""},
+		{"a/b/c", "../../d", ""},
+		{"lib/libx.so", "", "empty symlink target"},
+		{"bin/app", "../../etc/passwd", "symlink target outside archive"},
+		{"app", "..", "symlink target outside archive"},
+		{"a/b", "../../..", "symlink target outside archive"},
+		{"bin/app", "/usr/bin/app", "absolute symlink target"},
+		{"bin/app", "C:/app.exe", "absolute symlink target"},
+		{"bin/app", "..\\..\\app", "backslash in symlink target"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"->"+tt.target, func(t *testing.T) {
+			if reason := checkEntryLink(tt.name, tt.target); reason != tt.reason {
+				t.Fatalf("checkEntryLink(%q, %q) = %q, want %q", tt.name, tt.target, reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestValidateArchive(t *testing.T) {
+	file := func(name string, size uint64) archiveEntry { return archiveEntry{Name: name, Size: size} }
+	dir := func(name string) archiveEntry { return archiveEntry{Name: name, IsDir: true} }
+
+	tests := []struct {
+		name     string
+		entries  []archiveEntry
+		limits   archiveLimits
+		names    []string
+		problems []string // "имя: причина"
+	}{
+		{
+			name:    "valid",
+			entries: []archiveEntry{dir("bin/"), file("bin/app", 10), file("./readme.txt", 1)},
+			names:   []string{"", "bin/app", "readme.txt"},
+		},
+		{
+			// tar -czf x.tgz -C dir .
+			name:    "current directory",
+			entries: []archiveEntry{dir("./"), dir("./bin/"), file("./bin/app", 1)},
+			names:   []string{"", "", "bin/app"},
+		},
+		{
+			name:     "traversal and absolute",
+			entries:  []archiveEntry{file("../x", 1), file("/x", 1), file("C:/x", 1), file("a\\b", 1), file("ok", 1)},
+			names:    []string{"", "", "", "", "ok"},
+			problems: []string{"../x: path traversal", "/x: absolute path", "C:/x: absolute path", "a\\b: backslash in name"},
+		},
+		{
+			name:     "duplicate",
+			entries:  []archiveEntry{file("a/b", 1), file("a//b", 1)},
+			names:    []string{"a/b", ""},
+			problems: []string{"a//b: duplicate of a/b"},
+		},
+		{
+			name:     "case collision",
+			entries:  []archiveEntry{file("App.exe", 1), file("app.EXE", 1)},
+			names:    []string{"App.exe", ""},
+			problems: []string{"app.EXE: case-insensitive collision with App.exe"},
+		},
+		{
+			name:     "file and directory",
+			entries:  []archiveEntry{file("lib", 1), file("LIB/x.so", 1)},
+			names:    []string{"lib", "LIB/x.so"},
+			problems: []string{"lib: file conflicts with directory of LIB/x.so"},
+		},
+		{
+			name:     "reserved",
+			entries:  []archiveEntry{file("./.update_manifest.json", 1), file(".UPDATE_FILE_INFO.txt", 1), file("bin/.update_manifest.json", 1)},
+			names:    []string{"", "", "bin/.update_manifest.json"},
+			problems: []string{"./.update_manifest.json: reserved name", ".UPDATE_FILE_INFO.txt: reserved name"},
+		},
+		{
+			name:     "unsupported",
+			entries:  []archiveEntry{{Name: "dev", Unsupported: "unsupported entry type"}},
+			names:    []string{""},
+			problems: []string{"dev: unsupported entry type"},
+		},
+		{
+			name:     "too many entries",
+			entries:  []archiveEntry{file("a", 1), file("b", 1), file("c", 1)},
+			limits:   archiveLimits{MaxEntries: 2},
+			names:    []string{"a", "b", "c"},
+			problems: []string{": too many entries: 3, max 2"},
+		},
+		{
+			name:     "unpacked size",
+			entries:  []archiveEntry{file("a", 6), file("b", 5)},
+			limits:   archiveLimits{MaxUnpackedSize: 10},
+			names:    []string{"a", "b"},
+			problems: []string{": unpacked size too large: 11 bytes, max 10"},
+		},
+		{
+			name:    "unpacked size at limit",
+			entries: []archiveEntry{file("a", 5), file("b", 5)},
+			limits:  archiveLimits{MaxEntries: 2, MaxUnpackedSize: 10},
+			names:   []string{"a", "b"},
+		},
+		{
+			// заявленный размер не должен переполнять сумму
+			name:     "size overflow",
+			entries:  []archiveEntry{file("a", 1<<63), file("b", 1<<63), file("c", 1)},
+			limits:   archiveLimits{MaxUnpackedSize: 10},
+			names:    []string{"a", "b", "c"},
+			problems: []string{": unpacked size too large: 18446744073709551615 bytes, max 10"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, problems := validateArchive(tt.entries, tt.limits)
+			if !reflect.DeepEqual(names, tt.names) {
+				t.Errorf("names = %q, want %q", names, tt.names)
+			}
+
+			var got []string
+			for _, p := range problems {
+				got = append(got, p.Name+": "+p.Reason)
+			}
+			if !reflect.DeepEqual(got, tt.problems) {
+				t.Errorf("problems = %q, want %q", got, tt.problems)
+			}
+		})
+	}
+}
+
+// testTar архив как от git archive: общий заголовок pax, каталог "./" и файлы
+func testTar(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	headers := []*tar.Header{
+		{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header", PAXRecords: map[string]string{"comment": "abc"}},
+		{Typeflag: tar.TypeDir, Name: "./", Mode: 0755},
+	}
+	for _, h := range headers {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"./bin/app", "./readme.txt"} {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0755, Size: int64(len(files[name]))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTarArchive(t *testing.T) {
+	files := map[string]string{"./bin/app": "binary", "./readme.txt": "text"}
+	data := testTar(t, files)
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zst := zw.EncodeAll(data, nil)
+
+	for format, archive := range map[string][]byte{formatTar: data, formatTarGzip: gz.Bytes(), formatTarZstd: zst} {
+		t.Run(format, func(t *testing.T) {
+			a, err := detectArchive(bytes.NewReader(archive), int64(len(archive)), archiveLimits{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Format() != format {
+				t.Fatalf("format = %s, want %s", a.Format(), format)
+			}
+
+			entries, err := a.Entries()
+			if err != nil {
+				t.Fatal(err)
+			}
+			names, problems := validateArchive(entries, archiveLimits{MaxEntries: 10})
+			if len(problems) > 0 {
+				t.Fatalf("problems: %v", problems)
+			}
+			if want := []string{"", "bin/app", "readme.txt"}; !reflect.DeepEqual(names, want) {
+				t.Fatalf("names = %q, want %q", names, want)
+			}
+
+			got := map[string]string{}
+			err = a.Walk(func(i int, r io.Reader) error {
+				b, err := io.ReadAll(r)
+				got[entries[i].Name] = string(b)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, files) {
+				t.Fatalf("content = %v, want %v", got, files)
+			}
+		})
+	}
+}
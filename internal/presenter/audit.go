@@ -0,0 +1,147 @@
+package presenter
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// максимальный размер текста ошибки в журнале аудита
+const maxAuditResult = 4096
+
+// auditWriter запоминает код ответа и текст ошибки для журнала аудита
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= http.StatusBadRequest && w.body.Len() < maxAuditResult {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+type auditParamsKeyType string
+
+const auditParamsKey = auditParamsKeyType("AuditParamsKey")
+
+// auditParam добавить параметр операции в журнал аудита. Для операций, параметры которых передаются в теле запроса
+func auditParam(r *http.Request, name string, value string) {
+	if params, ok := r.Context().Value(auditParamsKey).(map[string]string); ok && len(value) > 0 {
+		// некорректный UTF-8 postgres не примет, и запись в журнал будет потеряна
+		params[name] = strings.ToValidUTF8(value, "\uFFFD")
+	}
+}
+
+// auditResult текст ошибки для журнала: не длиннее maxAuditResult байт, обрезается по границе символа UTF-8
+func auditResult(body string) string {
+	res := strings.ToValidUTF8(strings.TrimSpace(body), "\uFFFD")
+	if len(res) <= maxAuditResult {
+		return res
+	}
+
+	n := maxAuditResult
+	for n > 0 && !utf8.RuneStart(res[n]) {
+		n--
+	}
+	return res[:n]
+}
+
+// audited запись административной операции в журнал аудита.
+// Параметры операции обработчик добавляет через auditParam после проверки прав и разбора запроса:
+// после ответа тело запроса не читается, чтобы не принимать данные от клиентов без прав
+func (p *Service) audited(operation string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		aw := &auditWriter{ResponseWriter: w}
+		handlerParams := map[string]string{}
+		r = r.WithContext(context.WithValue(r.Context(), auditParamsKey, handlerParams))
+		next.ServeHTTP(aw, r)
+
+		rec := entity.AuditRecord{
+			Operation: operation,
+			Status:    aw.status,
+			Params:    handlerParams,
+		}
+		if rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+		if rec.Status >= http.StatusBadRequest {
+			rec.Result = auditResult(aw.body.String())
+		}
+		rec.Channel = rec.Params["channel"]
+		if ci := entity.GetClientInfoFromContext(r.Context()); ci != nil {
+			rec.Token = ci.Token
+			rec.IP = ci.IP
+			rec.RealIP = ci.RealIP
+		}
+
+		// контекст запроса может быть уже отменен, а запись в журнал терять нельзя
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(p.config.DbWriteTimeout))
+		defer cancel()
+
+		if err := p.audit.AddAudit(rec, ctx); err != nil {
+			p.logger.Error("audit %s: %v", operation, err)
+		}
+	}
+}
+
+// addAuditParams параметры операции добавления обновления. Вызывается только после разбора формы
+func addAuditParams(r *http.Request) {
+	for _, name := range []string{"channel", "version", "buildTime", "info", "enabled", "base", "removed", "dryRun"} {
+		auditParam(r, name, r.FormValue(name))
+	}
+}
+
+// журнал административных операций
+func (p *Service) auditLog() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := p.checkRights(r, entity.ScopeAdmin); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		statFilter, err := parseStatFilter(r)
+		if err != nil {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+			return
+		}
+
+		filter := entity.AuditFilter{
+			From:      statFilter.From,
+			To:        statFilter.To,
+			Channel:   statFilter.Channel,
+			Operation: r.URL.Query().Get("operation"),
+		}
+		if limit := r.URL.Query().Get("limit"); len(limit) > 0 {
+			if filter.Limit, err = strconv.Atoi(limit); err != nil {
+				p.controller.RespondError(w, http.StatusBadRequest, nerr.NewFmt("invalid 'limit': %s", limit))
+				return
+			}
+		}
+
+		records, err := p.audit.Audit(filter, r.Context())
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+
+		p.controller.RespondData(w, http.StatusOK, "application/json; charset=utf-8", records)
+	}
+}
@@ -0,0 +1,64 @@
+package presenter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/n-r-w/updsrv/internal/config"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// testAudit журнал аудита в памяти
+type testAudit struct {
+	records []entity.AuditRecord
+}
+
+func (a *testAudit) AddAudit(rec entity.AuditRecord, ctx context.Context) error {
+	a.records = append(a.records, rec)
+	return nil
+}
+
+func (a *testAudit) Audit(filter entity.AuditFilter, ctx context.Context) ([]entity.AuditRecord, error) {
+	return a.records, nil
+}
+
+func TestAuditedTruncatesOnRuneBoundary(t *testing.T) {
+	audit := &testAudit{}
+	p := &Service{audit: audit, config: &config.Config{DbWriteTimeout: 1}}
+
+	// 3 байта ASCII сдвигают границу maxAuditResult внутрь двухбайтового символа
+	body := "abc" + strings.Repeat("ошибка ", maxAuditResult/len("ошибка "))
+	handler := p.audited(entity.AuditAdd, func(w http.ResponseWriter, r *http.Request) {
+		auditParam(r, "info", "bad \xff utf8")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(body))
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/add", nil))
+
+	if len(audit.records) != 1 {
+		t.Fatalf("records = %d, want 1", len(audit.records))
+	}
+	rec := audit.records[0]
+	if len(rec.Result) > maxAuditResult || len(rec.Result) < maxAuditResult-utf8.UTFMax {
+		t.Fatalf("result length = %d, max %d", len(rec.Result), maxAuditResult)
+	}
+	if !utf8.ValidString(rec.Result) || !strings.HasPrefix(body, rec.Result) {
+		t.Fatalf("result is not a valid UTF-8 prefix of the body")
+	}
+	if !utf8.ValidString(rec.Params["info"]) {
+		t.Fatalf("param is not valid UTF-8: %q", rec.Params["info"])
+	}
+}
+
+func TestAuditResult(t *testing.T) {
+	for _, body := range []string{"", " short ", strings.Repeat("я", maxAuditResult), "x" + strings.Repeat("я", maxAuditResult), "\xff\xfe"} {
+		res := auditResult(body)
+		if !utf8.ValidString(res) || len(res) > maxAuditResult {
+			t.Errorf("auditResult(%d bytes) = %d bytes, valid %v", len(body), len(res), utf8.ValidString(res))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package presenter
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// список клиентов с последней известной версией
+func (p *Service) fleet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := p.checkRights(r, entity.ScopeAdmin); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		filter, err := parseFleetFilter(r)
+		if err != nil {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+			return
+		}
+
+		clients, err := p.fleetRepo.Fleet(filter, r.Context())
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+
+		p.controller.RespondData(w, http.StatusOK, "application/json; charset=utf-8", clients)
+	}
+}
+
+// количество клиентов по каналам и версиям
+func (p *Service) fleetVersions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := p.checkRights(r, entity.ScopeAdmin); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		filter, err := parseFleetFilter(r)
+		if err != nil {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+			return
+		}
+
+		versions, err := p.fleetRepo.FleetVersions(filter, r.Context())
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+
+		p.controller.RespondData(w, http.StatusOK, "application/json; charset=utf-8", versions)
+	}
+}
+
+// фильтр из параметров запроса: channel, version (например 4.1), activeDays, inactiveDays, limit
+func parseFleetFilter(r *http.Request) (entity.FleetFilter, error) {
+	var err error
+	query := r.URL.Query()
+
+	filter := entity.FleetFilter{
+		Channel: query.Get("channel"),
+	}
+
+	if v := query.Get("version"); len(v) > 0 {
+		if filter.Version, filter.VersionParts, err = entity.ParseVersion(v); err != nil {
+			return entity.FleetFilter{}, err
+		}
+	}
+
+	for name, value := range map[string]*int{
+		"activeDays":   &filter.ActiveDays,
+		"inactiveDays": &filter.InactiveDays,
+		"limit":        &filter.Limit,
+	} {
+		v := query.Get(name)
+		if len(v) == 0 {
+			continue
+		}
+		if *value, err = strconv.Atoi(v); err != nil || *value < 0 {
+			return entity.FleetFilter{}, nerr.NewFmt("invalid '%s': %s", name, v)
+		}
+	}
+
+	return filter, nil
+}
@@ -1,14 +1,15 @@
 package presenter
 
 import (
-	"archive/zip"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/n-r-w/eno"
 	"github.com/n-r-w/nerr"
 	"github.com/n-r-w/tools"
 	"github.com/n-r-w/updsrv/internal/entity"
@@ -17,7 +18,7 @@ import (
 // добавить новую версию
 func (p *Service) add() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := p.checkRights(r, true); err != nil {
+		if err := p.checkRights(r, entity.ScopeWrite); err != nil {
 			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
 			return
 		}
@@ -26,147 +27,189 @@ func (p *Service) add() http.HandlerFunc {
 			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
 			return
 		}
+		addAuditParams(r)
 
-		// файл с ПО
-		file, header, err := r.FormFile("update")
+		info, err := updateInfoFromForm(r)
 		if err != nil {
 			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
 			return
 		}
-		defer file.Close()
 
-		zr, err := zip.NewReader(file, header.Size)
-		if err != nil {
+		var opts publishOptions
+		if opts.Overlay, err = overlayFromForm(r); err != nil {
 			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
 			return
 		}
-
-		var files []entity.FileInfo
-		for _, zipFile := range zr.File {
-			f, err := zipFile.Open()
-			if err != nil || len(zipFile.Name) == 0 {
-				p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+		if dryRun := r.FormValue("dryRun"); len(dryRun) > 0 {
+			if opts.DryRun, err = strconv.ParseBool(dryRun); err != nil {
+				p.controller.RespondError(w, http.StatusBadRequest, nerr.New(fmt.Errorf("invalid 'dryRun': %s", dryRun)))
 				return
 			}
+		}
 
-			if zipFile.Name[len(zipFile.Name)-1] == '/' {
-				continue
-			}
+		// обновление: архив в поле update или дерево каталогов в полях files и paths
+		archive, closeArchive, err := uploadedArchive(r, p.archiveLimits())
+		if opts.Overlay != nil && errors.Is(err, http.ErrMissingFile) {
+			// при наложении можно только удалить файлы базовой версии
+			archive, closeArchive, err = &treeArchive{}, func() {}, nil
+		}
+		if err != nil {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+			return
+		}
+		defer closeArchive()
 
-			fi := entity.FileInfo{}
-			fi.Name = zipFile.Name
+		p.publish(w, r, archive, &info, opts)
+	}
+}
 
-			fi.Data, err = ioutil.ReadAll(f)
-			f.Close()
-			if err != nil {
-				p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
-				return
-			}
+// publish проверка и распаковка архива, добавление версии в БД. Общая часть для загрузки одним запросом и частями.
+// Возвращает false, если версия не добавлена (ответ с ошибкой или результат пробной публикации уже отправлен)
+func (p *Service) publish(w http.ResponseWriter, r *http.Request, archive archiveReader, info *entity.UpdateInfo, opts publishOptions) bool {
+	if err := p.checkRights(r, entity.ScopeWrite, info.Channel); err != nil {
+		p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+		return false
+	}
 
-			if fi.Checksum, err = tools.Sha256sum(fi.Data); err != nil {
+	// проверяем архив целиком до распаковки
+	entries, err := archive.Entries()
+	if errors.Is(err, errUnpackedTooLarge) {
+		p.respondArchiveProblems(w, []entity.ArchiveProblem{{Reason: err.Error()}})
+		return false
+	}
+	if err != nil {
+		p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+		return false
+	}
+	names, problems := validateArchive(entries, p.archiveLimits())
+	if len(problems) > 0 {
+		p.respondArchiveProblems(w, problems)
+		return false
+	}
+
+	files, problems, err := readArchive(archive, entries, names)
+	if err != nil {
+		p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+		return false
+	}
+	if len(problems) > 0 {
+		p.respondArchiveProblems(w, problems)
+		return false
+	}
+
+	if opts.Overlay != nil {
+		if files, problems, err = p.applyOverlay(r, info.Channel, opts.Overlay, files); err != nil {
+			if errors.Is(err, eno.ErrNotFound) {
+				p.controller.RespondError(w, http.StatusNotFound, nerr.New(err))
+			} else {
 				p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
-				return
 			}
-
-			files = append(files, fi)
+			return false
+		}
+		if len(problems) > 0 {
+			p.respondArchiveProblems(w, problems)
+			return false
 		}
+	}
 
-		// информация
-		var info entity.UpdateInfo
-		info.Files = files
+	info.Files = files
+	if info.BuildTime.IsZero() {
+		info.BuildTime = time.Now()
+	}
 
-		buildTime := r.FormValue("buildTime")
-		if len(buildTime) == 0 {
-			info.BuildTime = time.Now()
-		} else {
-			info.BuildTime, err = time.Parse("2006-01-02T15:04", buildTime)
-		}
+	if opts.DryRun {
+		preview, err := p.repo.Preview(info, r.Context())
 		if err != nil {
-			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
-			return
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return false
 		}
+		p.controller.RespondData(w, http.StatusOK, "application/json; charset=utf-8", preview)
+		return false
+	}
 
-		info.Channel = r.FormValue("channel")
-		if len(info.Channel) == 0 {
-			p.controller.RespondError(w, http.StatusBadRequest, nerr.New("no channel"))
-			return
-		}
+	receipt, err := p.repo.Add(info, r.Context())
+	if err != nil {
+		p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+		return false
+	}
 
-		info.Info = r.FormValue("info")
+	p.controller.RespondData(w, http.StatusCreated, "application/json; charset=utf-8", receipt)
+	return true
+}
 
-		version := r.FormValue("version")
-		if len(version) == 0 {
-			p.controller.RespondError(w, http.StatusBadRequest, nerr.New("no version"))
-			return
-		}
+// publishOptions режимы публикации
+type publishOptions struct {
+	Overlay *overlayRequest // наложение на базовую версию, nil - архив содержит все файлы версии
+	DryRun  bool            // только проверить архив и показать изменения, без записи в БД
+}
 
-		if v := strings.Split(version, "."); len(v) == 0 || len(v) > 4 {
-			p.controller.RespondError(w, http.StatusBadRequest, nerr.NewFmt("invalid version %s", r.FormValue("version")))
-			return
+// updateInfoFromForm параметры новой версии из полей формы: channel, version, info, enabled, buildTime.
+// Если buildTime не задано, то BuildTime нулевое
+func updateInfoFromForm(r *http.Request) (entity.UpdateInfo, error) {
+	var info entity.UpdateInfo
+	var err error
 
-		} else {
-			if info.Version.Major, err = strconv.Atoi(v[0]); err != nil {
-				p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
-				return
-			}
-			if len(v) >= 2 {
-				if info.Version.Minor, err = strconv.Atoi(v[1]); err != nil {
-					p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
-					return
-				}
-			}
-			if len(v) >= 3 {
-				if info.Version.Patch, err = strconv.Atoi(v[2]); err != nil {
-					p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
-					return
-				}
-			}
-			if len(v) >= 4 {
-				if info.Version.Revision, err = strconv.Atoi(v[3]); err != nil {
-					p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
-					return
-				}
-			}
+	if buildTime := r.FormValue("buildTime"); len(buildTime) > 0 {
+		if info.BuildTime, err = time.Parse("2006-01-02T15:04", buildTime); err != nil {
+			return entity.UpdateInfo{}, err
 		}
+	}
 
-		enabled := r.FormValue("enabled")
-		if len(enabled) == 0 || strings.EqualFold(enabled, "true") {
-			info.Enabled = true
-		} else if strings.EqualFold(enabled, "false") {
-			info.Enabled = false
-		} else {
-			p.controller.RespondError(w, http.StatusBadRequest, nerr.NewFmt("invalid 'enabled': %s", enabled))
-			return
-		}
+	info.Channel = r.FormValue("channel")
+	if len(info.Channel) == 0 {
+		return entity.UpdateInfo{}, fmt.Errorf("no channel")
+	}
 
-		if err := p.repo.Add(&info, r.Context()); err != nil {
-			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
-			return
-		}
+	info.Info = r.FormValue("info")
 
-		p.controller.RespondData(w, http.StatusCreated, "application/json; charset=utf-8", nil)
+	version := r.FormValue("version")
+	if len(version) == 0 {
+		return entity.UpdateInfo{}, fmt.Errorf("no version")
+	}
+	if info.Version, _, err = entity.ParseVersion(version); err != nil {
+		return entity.UpdateInfo{}, err
 	}
+
+	enabled := r.FormValue("enabled")
+	if len(enabled) == 0 || strings.EqualFold(enabled, "true") {
+		info.Enabled = true
+	} else if strings.EqualFold(enabled, "false") {
+		info.Enabled = false
+	} else {
+		return entity.UpdateInfo{}, fmt.Errorf("invalid 'enabled': %s", enabled)
+	}
+
+	return info, nil
 }
 
 // проверить наличие новой версии
 func (p *Service) check() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := p.checkRights(r, false); err != nil {
+		if err := p.checkRights(r, entity.ScopeRead); err != nil {
 			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
 			return
 		}
 
 		// парсим входящий json
 		var checkRequest entity.CheckRequest
-		if err := json.NewDecoder(r.Body).Decode(&checkRequest); err != nil {
+		var err error
+		if err = json.NewDecoder(r.Body).Decode(&checkRequest); err != nil {
 			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
 			return
 		}
+		if err = p.checkRights(r, entity.ScopeRead, checkRequest.Channel); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
 
 		clientInfo := entity.GetClientInfoFromContext(r.Context())
 		clientInfo.LocalIP = checkRequest.LocalIP
 		clientInfo.AppLogin = checkRequest.AppLogin
 		clientInfo.OsLogin = checkRequest.OsLogin
+		if clientInfo.ID, err = p.clientIdentity(r, checkRequest); err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
 
 		found, updateInfo, err := p.repo.Check(checkRequest.Channel, checkRequest.Version, r.Context())
 		if err != nil {
@@ -186,7 +229,7 @@ func (p *Service) check() http.HandlerFunc {
 // получить новую версию
 func (p *Service) update() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := p.checkRights(r, false); err != nil {
+		if err := p.checkRights(r, entity.ScopeRead); err != nil {
 			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
 			return
 		}
@@ -197,13 +240,29 @@ func (p *Service) update() http.HandlerFunc {
 			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
 			return
 		}
+		if err := p.checkRights(r, entity.ScopeRead, updateRequest.Channel); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		// формат архива из запроса или заголовка Accept
+		format, err := packageFormat(updateRequest.Format, r.Header.Get("Accept"))
+		if err != nil {
+			p.controller.RespondError(w, http.StatusNotAcceptable, nerr.New(err))
+			return
+		}
 
 		clientInfo := entity.GetClientInfoFromContext(r.Context())
 		clientInfo.LocalIP = updateRequest.LocalIP
 		clientInfo.AppLogin = updateRequest.AppLogin
 		clientInfo.OsLogin = updateRequest.OsLogin
 
-		data, updateInfo, err := p.repo.Update(updateRequest.Channel, updateRequest.Version, r.Context())
+		data, updateInfo, err := p.repo.Update(updateRequest.Channel, updateRequest.Version, format, r.Context())
+		if errors.Is(err, eno.ErrTooManyRequests) {
+			// сработал общий лимит на подготовку обновлений
+			p.respondTooManyRequests(w, time.Second)
+			return
+		}
 		if err != nil {
 			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
 			return
@@ -220,6 +279,104 @@ func (p *Service) update() http.HandlerFunc {
 		w.Header().Set("Version-Patch", strconv.Itoa(updateInfo.Version.Patch))
 		w.Header().Set("Version-Revision", strconv.Itoa(updateInfo.Version.Revision))
 
-		p.controller.RespondData(w, http.StatusOK, "application/zip", data)
+		w.Header().Set("Vary", "Accept")
+		p.controller.RespondData(w, http.StatusOK, entity.PackageContentType(format), data)
+	}
+}
+
+// типы содержимого в заголовке Accept, которым соответствуют форматы архива обновления
+var acceptFormats = map[string]string{
+	"application/zip":        entity.PackageZip,
+	"application/x-zip":      entity.PackageZip,
+	"application/zstd":       entity.PackageTarZstd,
+	"application/x-zstd":     entity.PackageTarZstd,
+	"application/x-tar+zstd": entity.PackageTarZstd,
+}
+
+// форматы архива обновления в порядке предпочтения при равном q
+var packageFormats = []string{entity.PackageZip, entity.PackageTarZstd}
+
+// acceptRange элемент заголовка Accept, который определил q для формата
+type acceptRange struct {
+	q           float64
+	specificity int // 2 - точный тип, 1 - application/*, 0 - */*
+	index       int // номер элемента в заголовке
+}
+
+// packageFormat формат архива обновления. Поле format запроса имеет приоритет над заголовком Accept.
+// Из Accept выбирается поддерживаемый тип с наибольшим q. Для каждого формата q берется из самого точного
+// подходящего элемента, поэтому application/zip;q=0 запрещает zip даже при */*. Без Accept - zip
+func packageFormat(requested string, accept string) (string, error) {
+	if len(requested) > 0 {
+		if !entity.ValidPackageFormat(requested) {
+			return "", fmt.Errorf("unsupported format %s, supported: %s, %s", requested, entity.PackageZip, entity.PackageTarZstd)
+		}
+		return requested, nil
 	}
+
+	if len(strings.TrimSpace(accept)) == 0 {
+		return entity.PackageZip, nil
+	}
+
+	ranges := map[string]acceptRange{}
+	for i, item := range strings.Split(accept, ",") {
+		params := strings.Split(item, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		var formats []string
+		specificity := 2
+		switch mediaType {
+		case "*/*":
+			formats, specificity = packageFormats, 0
+		case "application/*":
+			formats, specificity = packageFormats, 1
+		default:
+			if f, ok := acceptFormats[mediaType]; ok {
+				formats = []string{f}
+			}
+		}
+
+		for _, f := range formats {
+			if cur, ok := ranges[f]; !ok || specificity > cur.specificity {
+				ranges[f] = acceptRange{q: q, specificity: specificity, index: i}
+			}
+		}
+	}
+
+	format := ""
+	var best acceptRange
+	for _, f := range packageFormats {
+		r, ok := ranges[f]
+		if !ok || r.q <= 0 {
+			continue
+		}
+		if len(format) == 0 || r.q > best.q || (r.q == best.q && r.index < best.index) {
+			format = f
+			best = r
+		}
+	}
+
+	if len(format) == 0 {
+		return "", fmt.Errorf("not acceptable: %s, supported: application/zip, application/zstd", accept)
+	}
+	return format, nil
+}
+
+// идентификатор клиента: переданный клиентом или вычисленный по ip адресам и логину в ОС
+func (p *Service) clientIdentity(r *http.Request, req entity.CheckRequest) (string, error) {
+	if len(req.ClientID) > 0 {
+		return req.ClientID, nil
+	}
+
+	return tools.Sha256sum([]byte(strings.Join([]string{p.realIP(r), req.LocalIP, req.OsLogin}, "|")))
 }
@@ -0,0 +1,47 @@
+package presenter
+
+import (
+	"testing"
+
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+func TestPackageFormat(t *testing.T) {
+	tests := []struct {
+		requested string
+		accept    string
+		want      string // пустое - ошибка
+	}{
+		{"", "", entity.PackageZip},
+		{entity.PackageTarZstd, "application/zip", entity.PackageTarZstd},
+		{"rar", "", ""},
+		{"", "application/zip", entity.PackageZip},
+		{"", "application/zstd", entity.PackageTarZstd},
+		{"", "application/zstd, application/zip", entity.PackageTarZstd},
+		{"", "application/zip;q=0.5, application/zstd", entity.PackageTarZstd},
+		{"", "application/zip, application/zstd;q=0.9", entity.PackageZip},
+		{"", "*/*", entity.PackageZip},
+		{"", "application/*", entity.PackageZip},
+		{"", "application/zip;q=0, */*", entity.PackageTarZstd},
+		{"", "*/*, application/zip;q=0", entity.PackageTarZstd},
+		{"", "application/zip;q=0, application/zstd;q=0, */*", ""},
+		{"", "application/zip;q=0", ""},
+		{"", "application/zstd;q=0.2, application/*;q=0.5", entity.PackageZip},
+		{"", "text/html", ""},
+		{"", "APPLICATION/X-ZSTD ; q=1", entity.PackageTarZstd},
+	}
+	for _, tt := range tests {
+		t.Run(tt.requested+"|"+tt.accept, func(t *testing.T) {
+			got, err := packageFormat(tt.requested, tt.accept)
+			if len(tt.want) == 0 {
+				if err == nil {
+					t.Fatalf("packageFormat() = %s, want error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("packageFormat() = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package presenter
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// процесс работает
+func (p *Service) healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}
+}
+
+// сервер готов к работе: БД, схема, временный каталог. Результат каждой проверки в json
+func (p *Service) readyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res := p.health.Ready(r.Context())
+
+		status := http.StatusOK
+		if !res.Ready {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
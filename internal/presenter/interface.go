@@ -3,16 +3,90 @@ package presenter
 
 import (
 	"context"
+	"io"
+	"time"
 
 	"github.com/n-r-w/updsrv/internal/entity"
 )
 
 // UpdateInterface ...
 type UpdateInterface interface {
-	// Добавить обновление в БД. Внутри метода очищается содержимое Files.Data для экономии памяти
-	Add(updateInfo *entity.UpdateInfo, ctx context.Context) error
+	// Добавить обновление в БД. Внутри метода очищается содержимое Files.Data для экономии памяти.
+	// Файлы без Data с заданным DataID ссылаются на содержимое файла другой версии, без копирования
+	Add(updateInfo *entity.UpdateInfo, ctx context.Context) (entity.PublishReceipt, error)
 	// Проверка наличия обновления
 	Check(сhannel string, version entity.Version, ctx context.Context) (bool, entity.UpdateInfo, error)
-	// Вернуть дельту обновления в формате zip
-	Update(сhannel string, version entity.Version, ctx context.Context) ([]byte, entity.UpdateInfo, error)
+	// Вернуть дельту обновления в формате format: entity.PackageZip, entity.PackageTarZstd
+	Update(сhannel string, version entity.Version, format string, ctx context.Context) ([]byte, entity.UpdateInfo, error)
+	// Все версии канала без информации о файлах, от новых к старым
+	Versions(сhannel string, ctx context.Context) ([]entity.UpdateInfo, error)
+	// Что изменится при публикации версии, без записи в БД
+	Preview(ui *entity.UpdateInfo, ctx context.Context) (entity.PublishPreview, error)
+	// Файлы версии без содержимого, в том числе выключенной. Возвращает false, если версии нет
+	VersionFiles(сhannel string, version entity.Version, ctx context.Context) (bool, []entity.FileInfo, error)
+}
+
+// StatInterface ...
+type StatInterface interface {
+	// Статистика выдачи обновлений по каналам, версиям и дням
+	Stats(filter entity.StatFilter, ctx context.Context) ([]entity.Stat, error)
+}
+
+// ReportInterface ...
+type ReportInterface interface {
+	// Сохранить отчет клиента о результате установки обновления
+	AddReport(report entity.InstallReport, ctx context.Context) error
+	// Статистика результатов установки по каналам и версиям
+	InstallStats(filter entity.StatFilter, ctx context.Context) ([]entity.InstallStat, error)
+}
+
+// FleetInterface ...
+type FleetInterface interface {
+	// Список клиентов по фильтру
+	Fleet(filter entity.FleetFilter, ctx context.Context) (entity.FleetList, error)
+	// Количество клиентов по каналам и версиям
+	FleetVersions(filter entity.FleetFilter, ctx context.Context) ([]entity.FleetVersion, error)
+}
+
+// AuditInterface ...
+type AuditInterface interface {
+	// Добавить запись в журнал административных операций
+	AddAudit(rec entity.AuditRecord, ctx context.Context) error
+	// Журнал административных операций по фильтру
+	Audit(filter entity.AuditFilter, ctx context.Context) ([]entity.AuditRecord, error)
+}
+
+// TokenInterface ...
+type TokenInterface interface {
+	// Все токены доступа
+	Tokens(ctx context.Context) ([]entity.Token, error)
+	// Создать токен доступа
+	CreateToken(token entity.Token, ctx context.Context) (entity.Token, error)
+	// Сменить значение токена доступа. Возвращает false, если токен не найден
+	RotateToken(name string, secretHash string, ctx context.Context) (bool, entity.Token, error)
+	// Отозвать токен доступа. Возвращает false, если токен не найден
+	RevokeToken(name string, ctx context.Context) (bool, error)
+}
+
+// UploadInterface ...
+type UploadInterface interface {
+	// Создать сессию загрузки обновления частями
+	CreateUpload(session entity.UploadSession, ctx context.Context) error
+	// Сессия загрузки с полученными частями. Возвращает false, если сессии нет, она истекла или создана другим токеном
+	Upload(id string, token string, ctx context.Context) (bool, entity.UploadSession, error)
+	// Сохранить часть архива и продлить время жизни сессии. Возвращает false и общий размер частей, если он превысил
+	// maxSize или размер, заявленный при создании сессии
+	AddUploadChunk(id string, chunk entity.UploadChunk, data []byte, maxSize int64, expireTime time.Time, ctx context.Context) (bool, int64, error)
+	// Записать части архива по порядку в w
+	ReadUpload(session entity.UploadSession, w io.Writer, ctx context.Context) error
+	// Удалить сессию загрузки. Возвращает false, если сессия не найдена
+	DeleteUpload(id string, ctx context.Context) (bool, error)
+	// Удалить истекшие сессии загрузки. Возвращает количество удаленных
+	ExpireUploads(ctx context.Context) (int, error)
+}
+
+// HealthInterface ...
+type HealthInterface interface {
+	// Проверка готовности к работе
+	Ready(ctx context.Context) entity.Readiness
 }
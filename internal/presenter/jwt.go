@@ -0,0 +1,294 @@
+package presenter
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/n-r-w/lg"
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// допустимое расхождение часов при проверке exp и nbf
+const jwtLeeway = 30 * time.Second
+
+// минимальный размер ключа RSA в битах
+const jwtMinRSABits = 2048
+
+// jwtVerifier проверка JWT по ключам из локального JWKS файла
+type jwtVerifier struct {
+	path     string
+	audience string
+	issuer   string
+	logger   lg.Logger
+
+	mutex   sync.RWMutex
+	keys    map[string]crypto.PublicKey // ключ - kid
+	modTime time.Time
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject  string          `json:"sub"`
+	Issuer   string          `json:"iss"`
+	Audience json.RawMessage `json:"aud"`
+	Expire   int64           `json:"exp"`
+	NotFrom  int64           `json:"nbf"`
+	Scope    string          `json:"scope"`    // права через пробел: read write admin
+	Channels []string        `json:"channels"` // разрешенные каналы или шаблоны
+}
+
+func newJwtVerifier(path string, audience string, issuer string, logger lg.Logger) (*jwtVerifier, error) {
+	if len(audience) == 0 {
+		return nil, nerr.New("jwt audience undefined")
+	}
+
+	v := &jwtVerifier{
+		path:     path,
+		audience: audience,
+		issuer:   issuer,
+		logger:   logger,
+		keys:     map[string]crypto.PublicKey{},
+	}
+
+	if _, err := v.reload(); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// reload загрузка JWKS, если файл изменился. Возвращает истину, если ключи обновлены
+func (v *jwtVerifier) reload() (bool, error) {
+	stat, err := os.Stat(v.path)
+	if err != nil {
+		return false, nerr.New(err)
+	}
+
+	v.mutex.RLock()
+	changed := !stat.ModTime().Equal(v.modTime)
+	v.mutex.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(v.path)
+	if err != nil {
+		return false, nerr.New(err)
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return false, nerr.New(err, v.path)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range jwks.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return false, nerr.New(err, v.path)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	v.mutex.Lock()
+	v.keys = keys
+	v.modTime = stat.ModTime()
+	v.mutex.Unlock()
+
+	return true, nil
+}
+
+// watch отслеживание изменений JWKS файла
+func (v *jwtVerifier) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if changed, err := v.reload(); err != nil {
+			v.logger.Error("reload jwks: %v", err)
+		} else if changed {
+			v.logger.Info("jwks reloaded: %s", v.path)
+		}
+	}
+}
+
+// verify проверка подписи и срока действия JWT. Возвращает токен с правами из claims
+func (v *jwtVerifier) verify(raw string) (*entity.Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, nerr.New("invalid jwt")
+	}
+
+	var header jwtHeader
+	if err := decodeJwtPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	v.mutex.RLock()
+	key := v.keys[header.Kid]
+	v.mutex.RUnlock()
+	if key == nil {
+		return nil, nerr.NewFmt("unknown jwt key: %s", header.Kid)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nerr.New(err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, nerr.NewFmt("jwt key %s is not RSA", header.Kid)
+		}
+		hash := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, hash[:], signature); err != nil {
+			return nil, nerr.New("invalid jwt signature")
+		}
+	case "EdDSA":
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, nerr.NewFmt("jwt key %s is not Ed25519", header.Kid)
+		}
+		if !ed25519.Verify(edKey, signed, signature) {
+			return nil, nerr.New("invalid jwt signature")
+		}
+	default:
+		return nil, nerr.NewFmt("unsupported jwt algorithm: %s", header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeJwtPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if claims.Expire == 0 || now.After(time.Unix(claims.Expire, 0).Add(jwtLeeway)) {
+		return nil, nerr.New("jwt expired")
+	}
+	if claims.NotFrom != 0 && now.Add(jwtLeeway).Before(time.Unix(claims.NotFrom, 0)) {
+		return nil, nerr.New("jwt not valid yet")
+	}
+	if len(v.issuer) > 0 && claims.Issuer != v.issuer {
+		return nil, nerr.NewFmt("invalid jwt issuer: %s", claims.Issuer)
+	}
+	if !claims.hasAudience(v.audience) {
+		return nil, nerr.New("invalid jwt audience")
+	}
+	// по sub различаются клиенты в ограничениях частоты запросов, журнале аудита и сессиях загрузки
+	if len(strings.TrimSpace(claims.Subject)) == 0 {
+		return nil, nerr.New("jwt without sub")
+	}
+
+	token := &entity.Token{
+		Name:       "jwt:" + claims.Subject,
+		Channels:   claims.Channels,
+		ExpireTime: time.Unix(claims.Expire, 0).Add(jwtLeeway),
+	}
+	for _, s := range strings.Fields(claims.Scope) {
+		if entity.ValidScope(s) {
+			token.Scopes = append(token.Scopes, s)
+		}
+	}
+
+	return token, nil
+}
+
+// aud может быть строкой или массивом строк
+func (c *jwtClaims) hasAudience(audience string) bool {
+	var single string
+	if err := json.Unmarshal(c.Audience, &single); err == nil {
+		return single == audience
+	}
+
+	var list []string
+	if err := json.Unmarshal(c.Audience, &list); err == nil {
+		for _, a := range list {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeJwtPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return nerr.New(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return nerr.New(err)
+	}
+	return nil
+}
+
+// publicKey ключ из JWK. Неподдерживаемые виды ключей пропускаются
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if bits := key.N.BitLen(); bits < jwtMinRSABits {
+			return nil, nerr.NewFmt("RSA key %s is too short: %d bits, min %d", k.Kid, bits, jwtMinRSABits)
+		}
+		if len(e) > 4 || key.E < 3 || key.E%2 == 0 {
+			return nil, nerr.NewFmt("invalid RSA exponent: %s", k.Kid)
+		}
+		return key, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, nerr.NewFmt("invalid Ed25519 key size: %s", k.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, nil
+}
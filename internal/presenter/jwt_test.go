@@ -0,0 +1,168 @@
+package presenter
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testJwks ключи для проверки JWT: Ed25519 с kid "ed" и RSA 2048 с kid "rsa"
+type testJwks struct {
+	ed  ed25519.PrivateKey
+	rsa *rsa.PrivateKey
+}
+
+func newTestJwks(t *testing.T) (*testJwks, string) {
+	t.Helper()
+
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := &testJwks{ed: ed, rsa: rsaKey}
+	path := writeJwks(t, []jwk{
+		{Kid: "ed", Kty: "OKP", Crv: "Ed25519", X: b64(ed.Public().(ed25519.PublicKey))},
+		rsaJwk("rsa", &rsaKey.PublicKey),
+	})
+	return keys, path
+}
+
+func writeJwks(t *testing.T, keys []jwk) string {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func rsaJwk(kid string, key *rsa.PublicKey) jwk {
+	return jwk{Kid: kid, Kty: "RSA", N: b64(key.N.Bytes()), E: b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign JWT с заголовком header и claims, подписанный ключом kid
+func (k *testJwks) sign(t *testing.T, alg string, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+
+	var signature []byte
+	switch alg {
+	case "EdDSA":
+		signature = ed25519.Sign(k.ed, []byte(signed))
+	case "RS256":
+		hash := sha256.Sum256([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, hash[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + b64(signature)
+}
+
+func TestJwtVerify(t *testing.T) {
+	keys, path := newTestJwks(t)
+	v, err := newJwtVerifier(path, "updsrv", "issuer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub":      "ci",
+			"iss":      "issuer",
+			"aud":      "updsrv",
+			"exp":      time.Now().Add(time.Hour).Unix(),
+			"scope":    "read write unknown",
+			"channels": []string{"HRFILE_*"},
+		}
+	}
+	with := func(name string, value interface{}) map[string]interface{} {
+		c := valid()
+		if value == nil {
+			delete(c, name)
+		} else {
+			c[name] = value
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"eddsa", keys.sign(t, "EdDSA", "ed", valid()), true},
+		{"rs256", keys.sign(t, "RS256", "rsa", valid()), true},
+		{"audience list", keys.sign(t, "EdDSA", "ed", with("aud", []string{"other", "updsrv"})), true},
+		{"no sub", keys.sign(t, "EdDSA", "ed", with("sub", nil)), false},
+		{"blank sub", keys.sign(t, "EdDSA", "ed", with("sub", " ")), false},
+		{"expired", keys.sign(t, "EdDSA", "ed", with("exp", time.Now().Add(-time.Hour).Unix())), false},
+		{"no exp", keys.sign(t, "EdDSA", "ed", with("exp", nil)), false},
+		{"not valid yet", keys.sign(t, "EdDSA", "ed", with("nbf", time.Now().Add(time.Hour).Unix())), false},
+		{"wrong audience", keys.sign(t, "EdDSA", "ed", with("aud", "other")), false},
+		{"no audience", keys.sign(t, "EdDSA", "ed", with("aud", nil)), false},
+		{"wrong issuer", keys.sign(t, "EdDSA", "ed", with("iss", "other")), false},
+		{"unknown kid", keys.sign(t, "EdDSA", "unknown", valid()), false},
+		{"algorithm mismatch", keys.sign(t, "RS256", "ed", valid()), false},
+		{"alg none", keys.sign(t, "none", "ed", valid()), false},
+		{"tampered", keys.sign(t, "EdDSA", "ed", valid()) + "x", false},
+		{"malformed", "a.b", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := v.verify(tt.token)
+			if tt.ok != (err == nil) {
+				t.Fatalf("verify: ok %v, err %v", tt.ok, err)
+			}
+			if !tt.ok {
+				return
+			}
+			if token.Name != "jwt:ci" {
+				t.Errorf("name %s", token.Name)
+			}
+			if len(token.Scopes) != 2 || !token.HasScope("read") || !token.HasScope("write") {
+				t.Errorf("scopes %v", token.Scopes)
+			}
+			if !token.AllowChannel("HRFILE_PROD") || token.AllowChannel("OTHER") {
+				t.Errorf("channels %v", token.Channels)
+			}
+		})
+	}
+}
+
+func TestJwtShortRSAKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newJwtVerifier(writeJwks(t, []jwk{rsaJwk("short", &key.PublicKey)}), "updsrv", "", nil); err == nil {
+		t.Fatal("1024 bit RSA key accepted")
+	}
+}
@@ -0,0 +1,258 @@
+package presenter
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/n-r-w/eno"
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/updsrv/internal/config"
+	"golang.org/x/time/rate"
+)
+
+// через какое время неиспользуемый лимитер удаляется
+const limiterIdleTimeout = 10 * time.Minute
+
+// максимальное количество лимитеров в группе. При превышении удаляется давно не использованный
+const limiterMaxBuckets = 100000
+
+type limiterBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// limiterGroup набор лимитеров по ключу (токен или IP адрес)
+type limiterGroup struct {
+	mutex       sync.Mutex
+	limit       rate.Limit
+	burst       int
+	buckets     map[string]*limiterBucket
+	lastCleanup time.Time
+}
+
+func newLimiterGroup(limit float64, burst int) *limiterGroup {
+	if limit <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(limit))
+	}
+
+	return &limiterGroup{
+		limit:       rate.Limit(limit),
+		burst:       burst,
+		buckets:     map[string]*limiterBucket{},
+		lastCleanup: time.Now(),
+	}
+}
+
+// setLimit изменение ограничения с сохранением накопленного состояния лимитеров
+func (g *limiterGroup) setLimit(limit float64, burst int) {
+	if burst <= 0 {
+		burst = int(math.Ceil(limit))
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.limit == rate.Limit(limit) && g.burst == burst {
+		return
+	}
+
+	g.limit = rate.Limit(limit)
+	g.burst = burst
+	for _, b := range g.buckets {
+		b.limiter.SetLimit(g.limit)
+		b.limiter.SetBurst(g.burst)
+	}
+}
+
+// updateLimiterGroup применение нового ограничения к группе. Существующая группа обновляется на месте
+func updateLimiterGroup(g *limiterGroup, limit float64, burst int) *limiterGroup {
+	if g == nil || limit <= 0 {
+		return newLimiterGroup(limit, burst)
+	}
+	g.setLimit(limit, burst)
+	return g
+}
+
+// allow проверка возможности выполнить запрос. Если нельзя, то возвращает время, через которое можно повторить
+func (g *limiterGroup) allow(key string) (bool, time.Duration) {
+	if g == nil {
+		return true, 0
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+
+	// удаляем давно неиспользуемые лимитеры
+	if now.Sub(g.lastCleanup) > limiterIdleTimeout {
+		for k, b := range g.buckets {
+			if now.Sub(b.lastSeen) > limiterIdleTimeout {
+				delete(g.buckets, k)
+			}
+		}
+		g.lastCleanup = now
+	}
+
+	b := g.buckets[key]
+	if b == nil {
+		if len(g.buckets) >= limiterMaxBuckets {
+			g.evictOldest()
+		}
+		b = &limiterBucket{limiter: rate.NewLimiter(g.limit, g.burst)}
+		g.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	res := b.limiter.ReserveN(now, 1)
+	if !res.OK() {
+		return false, time.Second
+	}
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// evictOldest удаление лимитера, который дольше всех не использовался
+func (g *limiterGroup) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for k, b := range g.buckets {
+		if len(oldestKey) == 0 || b.lastSeen.Before(oldest) {
+			oldestKey = k
+			oldest = b.lastSeen
+		}
+	}
+	delete(g.buckets, oldestKey)
+}
+
+// endpointLimiter ограничения для одного метода API
+type endpointLimiter struct {
+	token *limiterGroup
+	ip    *limiterGroup
+}
+
+// updateEndpointLimiters ограничения по методам API из конфига. Лимитеры из current переиспользуются,
+// чтобы перечитывание конфига не сбрасывало счетчики
+func updateEndpointLimiters(current map[string]*endpointLimiter, cfg map[string]config.RateLimit) map[string]*endpointLimiter {
+	res := map[string]*endpointLimiter{}
+	for endpoint, l := range cfg {
+		old := current[endpoint]
+		if old == nil {
+			old = &endpointLimiter{}
+		}
+		res[endpoint] = &endpointLimiter{
+			token: updateLimiterGroup(old.token, l.Token, l.TokenBurst),
+			ip:    updateLimiterGroup(old.ip, l.IP, l.IPBurst),
+		}
+	}
+	return res
+}
+
+// limiterEndpoint ключ RATE_LIMITS для запроса: путь относительно /api, например "add" или "uploads/create"
+func limiterEndpoint(r *http.Request) string {
+	return strings.TrimPrefix(r.URL.Path, "/api/")
+}
+
+// unknownLimiterEndpoints ключи RATE_LIMITS, которым не соответствует ни один метод API
+func (p *Service) unknownLimiterEndpoints(cfg map[string]config.RateLimit) []string {
+	var res []string
+	for endpoint := range cfg {
+		if !p.routes["/api/"+endpoint] {
+			res = append(res, endpoint)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// ограничения для метода API. nil, если ограничений нет
+func (p *Service) limiter(endpoint string) *endpointLimiter {
+	p.limitersMutex.RLock()
+	defer p.limitersMutex.RUnlock()
+	return p.limiters[endpoint]
+}
+
+// Ограничение частоты запросов по IP адресу. Выполняется до аутентификации, чтобы ограничивать и перебор токенов
+func (p *Service) rateLimitIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := limiterEndpoint(r)
+		if l := p.limiter(endpoint); l != nil {
+			if ok, retry := l.ip.allow(p.realIP(r)); !ok {
+				p.metrics.RateLimitRejections.WithLabelValues(endpoint, "ip").Inc()
+				p.respondTooManyRequests(w, retry)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Ограничение частоты запросов по токену. Выполняется после аутентификации
+func (p *Service) rateLimitToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := limiterEndpoint(r)
+		if l := p.limiter(endpoint); l != nil {
+			if token := tokenFromContext(r.Context()); token != nil {
+				if ok, retry := l.token.allow(token.Name); !ok {
+					p.metrics.RateLimitRejections.WithLabelValues(endpoint, "token").Inc()
+					p.respondTooManyRequests(w, retry)
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Ответ 429 с заголовком Retry-After
+func (p *Service) respondTooManyRequests(w http.ResponseWriter, retry time.Duration) {
+	seconds := int(math.Ceil(retry.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	p.controller.RespondError(w, http.StatusTooManyRequests, nerr.New(eno.ErrTooManyRequests))
+}
+
+// IP адрес клиента без номера порта. Заголовок X-Real-IP учитывается только от прокси из TRUSTED_PROXIES,
+// иначе клиент мог бы подменять адрес и обходить ограничения по IP
+func (p *Service) realIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if header := r.Header.Get("X-Real-IP"); len(header) > 0 && net.ParseIP(header) != nil && p.trustedProxy(host) {
+		return header
+	}
+	return host
+}
+
+// trustedProxy входит ли адрес в TRUSTED_PROXIES
+func (p *Service) trustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range p.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package presenter
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/n-r-w/updsrv/internal/config"
+)
+
+func TestUpdateEndpointLimitersKeepsState(t *testing.T) {
+	limiters := updateEndpointLimiters(nil, map[string]config.RateLimit{"add": {Token: 1, TokenBurst: 1}})
+	if ok, _ := limiters["add"].token.allow("t"); !ok {
+		t.Fatal("first request rejected")
+	}
+	if ok, _ := limiters["add"].token.allow("t"); ok {
+		t.Fatal("second request allowed")
+	}
+
+	// тот же лимит: израсходованный запас не восстанавливается
+	limiters = updateEndpointLimiters(limiters, map[string]config.RateLimit{"add": {Token: 1, TokenBurst: 1}})
+	if ok, _ := limiters["add"].token.allow("t"); ok {
+		t.Fatal("reload reset the limiter")
+	}
+
+	// новый лимит применяется к существующим лимитерам
+	limiters = updateEndpointLimiters(limiters, map[string]config.RateLimit{"add": {Token: 0.001, TokenBurst: 1}})
+	if l := limiters["add"].token.buckets["t"].limiter.Limit(); l != 0.001 {
+		t.Fatalf("limit not updated: %v", l)
+	}
+
+	// ограничение снято
+	limiters = updateEndpointLimiters(limiters, map[string]config.RateLimit{"add": {}})
+	if ok, _ := limiters["add"].token.allow("t"); !ok {
+		t.Fatal("request rejected without limit")
+	}
+}
+
+func TestValidateTokenConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		ok   bool
+	}{
+		{"valid", config.Config{TokensRead: []string{"a"}, ClientCerts: map[string]config.ClientCert{
+			"CN=x": {Scopes: []string{"read"}, Channels: []string{"beta*"}}}}, true},
+		{"empty token", config.Config{TokensAdmin: []string{" "}}, false},
+		{"no scopes", config.Config{ClientCerts: map[string]config.ClientCert{"CN=x": {}}}, false},
+		{"bad scope", config.Config{ClientCerts: map[string]config.ClientCert{"CN=x": {Scopes: []string{"root"}}}}, false},
+		{"bad channel", config.Config{ClientCerts: map[string]config.ClientCert{
+			"CN=x": {Scopes: []string{"read"}, Channels: []string{"["}}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateTokenConfig(&tt.cfg); (err == nil) != tt.ok {
+				t.Fatalf("validateTokenConfig() = %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLimiterEndpoint(t *testing.T) {
+	for path, want := range map[string]string{
+		"/api/add":                 "add",
+		"/api/uploads/create":      "uploads/create",
+		"/api/admin/tokens/create": "admin/tokens/create",
+	} {
+		if got := limiterEndpoint(httptest.NewRequest("POST", path, nil)); got != want {
+			t.Errorf("limiterEndpoint(%s) = %s, want %s", path, got, want)
+		}
+	}
+}
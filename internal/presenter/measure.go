@@ -0,0 +1,64 @@
+package presenter
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// statusWriter запоминает код ответа для метрик
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// методы HTTP, которые попадают в метки как есть. Остальные - "other", клиент может прислать любой метод
+var metricMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// Сбор метрик количества и длительности запросов
+func (p *Service) measure(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r)
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+
+		// неизвестные пути не попадают в метки, чтобы не раздувать количество рядов
+		route := r.URL.Path
+		if !p.routes[route] {
+			route = "other"
+		}
+
+		method := r.Method
+		if !metricMethods[method] {
+			method = "other"
+		}
+
+		p.metrics.HttpRequests.WithLabelValues(route, method, strconv.Itoa(sw.status)).Inc()
+		p.metrics.HttpDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+	})
+}
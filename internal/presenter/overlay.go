@@ -0,0 +1,109 @@
+package presenter
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/n-r-w/eno"
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// overlayRequest публикация наложением: архив содержит только новые и измененные файлы,
+// остальные берутся из базовой версии того же канала
+type overlayRequest struct {
+	Base    entity.Version
+	Removed []string // удаленные относительно базовой версии файлы
+}
+
+// overlayFromForm параметры наложения из полей base и removed (несколько полей или по одному пути в строке).
+// nil, если base не задано
+func overlayFromForm(r *http.Request) (*overlayRequest, error) {
+	base := r.FormValue("base")
+	if len(base) == 0 {
+		if len(r.Form["removed"]) > 0 {
+			return nil, fmt.Errorf("removed requires base")
+		}
+		return nil, nil
+	}
+
+	var res overlayRequest
+	var err error
+	if res.Base, _, err = entity.ParseVersion(base); err != nil {
+		return nil, err
+	}
+
+	for _, value := range r.Form["removed"] {
+		for _, name := range strings.Split(value, "\n") {
+			if name = strings.TrimSpace(name); len(name) > 0 {
+				res.Removed = append(res.Removed, name)
+			}
+		}
+	}
+
+	return &res, nil
+}
+
+// applyOverlay полный набор файлов новой версии: файлы базовой версии без удаленных, поверх них загруженные.
+// Содержимое файлов базовой версии не загружается, в Add передается ссылка на него
+func (p *Service) applyOverlay(r *http.Request, channel string, overlay *overlayRequest, uploaded []entity.FileInfo) ([]entity.FileInfo, []entity.ArchiveProblem, error) {
+	found, base, err := p.repo.VersionFiles(channel, overlay.Base, r.Context())
+	if err != nil {
+		return nil, nil, err
+	}
+	if !found {
+		return nil, nil, nerr.New(eno.ErrNotFound, fmt.Sprintf("base version %s not found", overlay.Base.String()))
+	}
+
+	var problems []entity.ArchiveProblem
+
+	baseFiles := map[string]bool{}
+	for _, fi := range base {
+		baseFiles[fi.Name] = true
+	}
+	uploadedFiles := map[string]bool{}
+	for _, fi := range uploaded {
+		uploadedFiles[fi.Name] = true
+	}
+
+	removed := map[string]bool{}
+	for _, name := range overlay.Removed {
+		normalized, reason := normalizeEntryName(name)
+		switch {
+		case len(reason) > 0:
+		case !baseFiles[normalized]:
+			reason = "removed file not found in base version"
+		case uploadedFiles[normalized]:
+			reason = "file is both uploaded and removed"
+		}
+		if len(reason) > 0 {
+			problems = append(problems, entity.ArchiveProblem{Name: name, Reason: "removed: " + reason})
+			continue
+		}
+		removed[normalized] = true
+	}
+	if len(problems) > 0 {
+		return nil, problems, nil
+	}
+
+	files := make([]entity.FileInfo, 0, len(base)+len(uploaded))
+	for _, fi := range base {
+		if removed[fi.Name] || uploadedFiles[fi.Name] {
+			continue
+		}
+		files = append(files, fi)
+	}
+	files = append(files, uploaded...)
+
+	// новые файлы не должны конфликтовать с файлами базовой версии по регистру и каталогам
+	entries := make([]archiveEntry, len(files))
+	for i, fi := range files {
+		entries[i] = archiveEntry{Name: fi.Name}
+	}
+	if _, problems = validateArchive(entries, archiveLimits{MaxEntries: p.config.MaxUpdateFiles}); len(problems) > 0 {
+		return nil, problems, nil
+	}
+
+	return files, nil, nil
+}
@@ -1,100 +1,296 @@
 package presenter
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/n-r-w/eno"
 	"github.com/n-r-w/httprouter"
+	"github.com/n-r-w/lg"
 	"github.com/n-r-w/nerr"
 	"github.com/n-r-w/updsrv/internal/config"
 	"github.com/n-r-w/updsrv/internal/entity"
+	"github.com/n-r-w/updsrv/internal/metrics"
 )
 
 type Service struct {
 	controller httprouter.Router
 	repo       UpdateInterface
+	stat       StatInterface
+	reports    ReportInterface
+	fleetRepo  FleetInterface
+	audit      AuditInterface
+	tokenRepo  TokenInterface
+	uploads    UploadInterface
+	health     HealthInterface
 	config     *config.Config
+	logger     lg.Logger
 
-	tokens      map[string]bool // список всех токенов
-	tokensRead  map[string]bool // список токенов доступа на чтение
-	tokensWrite map[string]bool // список токенов доступа на запись
+	tokens *tokenStore  // токены доступа
+	jwt    *jwtVerifier // проверка JWT. nil, если не настроена
+
+	metrics *metrics.Service
+	routes  map[string]bool // зарегистрированные маршруты для меток метрик
+
+	trustedProxies []*net.IPNet // прокси, которым разрешено передавать адрес клиента в X-Real-IP
+
+	limitersMutex sync.RWMutex
+	limiters      map[string]*endpointLimiter // ограничения частоты запросов по методам API
 }
 
 // New Инициализация маршрутов
-func New(router httprouter.Router, repo UpdateInterface, config *config.Config) (*Service, error) {
+func New(router httprouter.Router, repo UpdateInterface, stat StatInterface, reports ReportInterface, fleet FleetInterface, audit AuditInterface,
+	tokenRepo TokenInterface, uploads UploadInterface, health HealthInterface, metrics *metrics.Service, config *config.Config, logger lg.Logger) (*Service, error) {
 	p := &Service{
-		controller:  router,
-		repo:        repo,
-		config:      config,
-		tokens:      map[string]bool{},
-		tokensRead:  map[string]bool{},
-		tokensWrite: map[string]bool{},
+		controller: router,
+		repo:       repo,
+		stat:       stat,
+		reports:    reports,
+		fleetRepo:  fleet,
+		audit:      audit,
+		tokenRepo:  tokenRepo,
+		uploads:    uploads,
+		health:     health,
+		config:     config,
+		logger:     logger,
+		tokens:     newTokenStore(config),
+		limiters:   updateEndpointLimiters(nil, config.RateLimits),
+		metrics:    metrics,
+		routes:     map[string]bool{"/metrics": true, "/healthz": true, "/readyz": true},
+	}
+
+	if err := validateTokenConfig(config); err != nil {
+		return nil, err
 	}
 
-	if len(config.TokensRead) == 0 {
-		return nil, nerr.New("no access read tokens")
+	var err error
+	if p.trustedProxies, err = config.TrustedProxyNets(); err != nil {
+		return nil, err
 	}
-	if len(config.TokensWrite) == 0 {
-		return nil, nerr.New("no access write tokens")
+
+	// инициализация хранилища токенов. Если БД недоступна, то работаем с токенами из конфига
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(config.DbReadTimeout))
+	defer cancel()
+	if err := p.reloadTokens(ctx); err != nil {
+		logger.Error("load tokens: %v", err)
 	}
 
-	// инициализация хранилища токенов
-	for _, v := range config.TokensRead {
-		p.tokensRead[v] = true
-		p.tokens[v] = true
+	if !p.tokens.hasScope(entity.ScopeRead) && !p.tokens.hasScope(entity.ScopeWrite) && !p.tokens.hasScope(entity.ScopeAdmin) &&
+		len(config.JwksPath) == 0 {
+		return nil, nerr.New("no access tokens")
 	}
-	for _, v := range config.TokensWrite {
-		p.tokensWrite[v] = true
-		p.tokens[v] = true
+	if !p.tokens.hasScope(entity.ScopeAdmin) && len(config.JwksPath) == 0 {
+		logger.Warn("no admin access tokens")
+	}
+
+	if config.TokensRefresh > 0 {
+		go p.refreshTokens()
 	}
 
+	// удаление брошенных сессий загрузки частями
+	go p.expireUploads()
+
+	if len(config.JwksPath) > 0 {
+		if p.jwt, err = newJwtVerifier(config.JwksPath, config.JwtAudience, config.JwtIssuer, logger); err != nil {
+			return nil, err
+		}
+		if config.JwksReload > 0 {
+			go p.jwt.watch(time.Second * time.Duration(config.JwksReload))
+		}
+	}
+
+	// ограничение частоты запросов по IP адресу. До проверки сессии, чтобы ограничивать и перебор токенов
+	router.AddMiddleware("/api", p.rateLimitIP)
 	// устанавливаем middleware для проверки валидности сессии
 	router.AddMiddleware("/api", p.authenticateUser)
+	// ограничение частоты запросов по токену
+	router.AddMiddleware("/api", p.rateLimitToken)
 
 	// добавить новую версию
-	router.AddRoute("/api", "/add", p.add(), "POST")
+	p.addRoute("/add", p.audited(entity.AuditAdd, p.add()), "POST")
 	// проверить наличие новой версии
-	router.AddRoute("/api", "/check", p.check(), "POST")
+	p.addRoute("/check", p.check(), "POST")
 	// получить новую версию
-	router.AddRoute("/api", "/update", p.update(), "POST")
+	p.addRoute("/update", p.update(), "POST")
+	// загрузка обновления частями
+	p.addRoute("/uploads/create", p.uploadCreate(), "POST")
+	p.addRoute("/uploads/chunk", p.uploadChunk(), "PUT")
+	p.addRoute("/uploads/status", p.uploadStatus(), "GET")
+	p.addRoute("/uploads/finalize", p.audited(entity.AuditAdd, p.uploadFinalize()), "POST")
+	p.addRoute("/uploads/cancel", p.uploadCancel(), "POST")
+	// список версий канала
+	p.addRoute("/versions", p.versions(), "GET")
+	// отчет о результате установки обновления
+	p.addRoute("/report", p.report(), "POST")
+	// статистика выдачи обновлений
+	p.addRoute("/admin/stats", p.stats(), "GET")
+	// статистика результатов установки обновлений
+	p.addRoute("/admin/installs", p.installStats(), "GET")
+	// клиенты и их версии
+	p.addRoute("/admin/clients", p.fleet(), "GET")
+	// количество клиентов по версиям
+	p.addRoute("/admin/clients/versions", p.fleetVersions(), "GET")
+	// журнал административных операций
+	p.addRoute("/admin/audit", p.auditLog(), "GET")
+	// токены доступа
+	p.addRoute("/admin/tokens", p.tokenList(), "GET")
+	p.addRoute("/admin/tokens/create", p.audited(entity.AuditTokenCreate, p.tokenCreate()), "POST")
+	p.addRoute("/admin/tokens/rotate", p.audited(entity.AuditTokenRotate, p.tokenRotate()), "POST")
+	p.addRoute("/admin/tokens/revoke", p.audited(entity.AuditTokenRevoke, p.tokenRevoke()), "POST")
+
+	if unknown := p.unknownLimiterEndpoints(config.RateLimits); len(unknown) > 0 {
+		logger.Warn("RATE_LIMITS: unknown API methods %s", strings.Join(unknown, ", "))
+	}
 
 	return p, nil
 }
 
+// Handler обработчик всех запросов: системные маршруты и маршруты API
+func (p *Service) Handler(api http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	// метрики в формате Prometheus, только с правами администратора
+	mux.Handle("/metrics", p.authenticateUser(p.metricsHandler()))
+	// процесс работает
+	mux.Handle("/healthz", p.healthz())
+	// сервер готов к работе
+	mux.Handle("/readyz", p.readyz())
+	mux.Handle("/", api)
+
+	return p.measure(mux)
+}
+
+// выдача метрик после проверки прав администратора
+func (p *Service) metricsHandler() http.HandlerFunc {
+	handler := p.metrics.Handler()
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := p.checkRights(r, entity.ScopeAdmin); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// регистрация маршрута API
+func (p *Service) addRoute(route string, handler http.HandlerFunc, method string) {
+	p.controller.AddRoute("/api", route, handler, method)
+	p.routes["/api"+route] = true
+}
+
 // Аутентификация пользователя
 func (p *Service) authenticateUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("X-Authorization")
-		if _, ok := p.tokens[token]; !ok {
-			p.controller.RespondError(w, http.StatusUnauthorized, nerr.New(eno.ErrNoAccess))
+		token, err := p.authenticate(r)
+		if err != nil {
+			p.controller.RespondError(w, http.StatusUnauthorized, nerr.New(eno.ErrNoAccess, err))
 			return
 		}
 
 		// добавляем в контекст инфу о клиенте
 		ci := &entity.ClientInfo{
+			Token:  token.Name,
 			IP:     r.RemoteAddr,
-			RealIP: r.Header.Get("X-Real-IP"),
-		}
-		if len(ci.RealIP) == 0 {
-			ci.RealIP = ci.IP
+			RealIP: p.realIP(r),
 		}
 		ctx := entity.PutClientInfoToContext(ci, r.Context())
+		ctx = putTokenToContext(token, ctx)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// Проверка прав
-func (p *Service) checkRights(r *http.Request, writeAccess bool) error {
-	token := r.Header.Get("X-Authorization")
-	if writeAccess {
-		if _, ok := p.tokensWrite[token]; !ok {
-			return nerr.New(eno.ErrNoAccess, "no write access")
+// Определение токена доступа: JWT из заголовка Authorization, токен из X-Authorization
+// или, если заголовков нет, проверенный клиентский сертификат
+func (p *Service) authenticate(r *http.Request) (*entity.Token, error) {
+	if auth := r.Header.Get("Authorization"); p.jwt != nil && strings.HasPrefix(auth, "Bearer ") {
+		token, err := p.jwt.verify(strings.TrimPrefix(auth, "Bearer "))
+		if err != nil {
+			return nil, err
+		}
+		return token, nil
+	}
+
+	if secret := r.Header.Get("X-Authorization"); len(secret) > 0 {
+		if token := p.tokens.get(secret); token != nil {
+			return token, nil
+		}
+		return nil, nerr.New("invalid token")
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		if token := p.tokens.getCert(r.TLS.VerifiedChains[0][0]); token != nil {
+			return token, nil
+		}
+		return nil, nerr.NewFmt("unknown client certificate: %s", r.TLS.VerifiedChains[0][0].Subject.String())
+	}
+
+	return nil, nerr.New("no token")
+}
+
+type tokenKeyType string
+
+const tokenKey = tokenKeyType("TokenKey")
+
+func putTokenToContext(t *entity.Token, ctx context.Context) context.Context {
+	return context.WithValue(ctx, tokenKey, t)
+}
+
+func tokenFromContext(ctx context.Context) *entity.Token {
+	if t, ok := ctx.Value(tokenKey).(*entity.Token); ok {
+		return t
+	}
+	return nil
+}
+
+// Проверка прав. Если указаны каналы, то проверяется и доступ к ним
+func (p *Service) checkRights(r *http.Request, scope string, channels ...string) error {
+	token := tokenFromContext(r.Context())
+	if token == nil || !token.HasScope(scope) {
+		return nerr.New(eno.ErrNoAccess, fmt.Sprintf("no %s access", scope))
+	}
+	for _, c := range channels {
+		if !token.AllowChannel(c) {
+			return nerr.New(eno.ErrNoAccess, fmt.Sprintf("no %s access to channel '%s' for token '%s'", scope, c, token.Name))
 		}
-	} else {
-		if _, ok := p.tokensRead[token]; !ok {
-			return nerr.New(eno.ErrNoAccess, "no read access")
+	}
+	return nil
+}
+
+// Имя токена для журналов: отпечаток значения, чтобы не раскрывать сам токен
+func tokenName(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(hash[:6])
+}
+
+// Reload применение новых токенов, прав по клиентским сертификатам и ограничений частоты запросов.
+// Некорректные токены и права не применяются. Состояние ограничений частоты запросов сохраняется
+func (p *Service) Reload(cfg *config.Config) error {
+	if err := validateTokenConfig(cfg); err != nil {
+		return err
+	}
+	if p.jwt == nil {
+		candidate := p.tokens.withConfig(cfg)
+		if !candidate.hasScope(entity.ScopeRead) && !candidate.hasScope(entity.ScopeWrite) && !candidate.hasScope(entity.ScopeAdmin) {
+			return nerr.New("no access tokens")
 		}
 	}
+
+	p.tokens.setConfig(cfg)
+
+	p.limitersMutex.Lock()
+	p.limiters = updateEndpointLimiters(p.limiters, cfg.RateLimits)
+	p.limitersMutex.Unlock()
+
+	if unknown := p.unknownLimiterEndpoints(cfg.RateLimits); len(unknown) > 0 {
+		p.logger.Warn("RATE_LIMITS: unknown API methods %s", strings.Join(unknown, ", "))
+	}
+
 	return nil
 }
@@ -0,0 +1,70 @@
+package presenter
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// отчет клиента о результате установки обновления
+func (p *Service) report() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := p.checkRights(r, entity.ScopeRead); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		// парсим входящий json
+		var report entity.InstallReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+			return
+		}
+		if len(report.Channel) == 0 {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New("no channel"))
+			return
+		}
+		if err := p.checkRights(r, entity.ScopeRead, report.Channel); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		clientInfo := entity.GetClientInfoFromContext(r.Context())
+		clientInfo.LocalIP = report.LocalIP
+		clientInfo.AppLogin = report.AppLogin
+		clientInfo.OsLogin = report.OsLogin
+
+		if err := p.reports.AddReport(report, r.Context()); err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+
+		p.controller.RespondData(w, http.StatusCreated, "application/json; charset=utf-8", nil)
+	}
+}
+
+// статистика результатов установки по каналам и версиям
+func (p *Service) installStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := p.checkRights(r, entity.ScopeAdmin); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		filter, err := parseStatFilter(r)
+		if err != nil {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+			return
+		}
+
+		stats, err := p.reports.InstallStats(filter, r.Context())
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+
+		p.controller.RespondData(w, http.StatusOK, "application/json; charset=utf-8", stats)
+	}
+}
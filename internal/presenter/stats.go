@@ -0,0 +1,63 @@
+package presenter
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// период статистики по умолчанию в днях
+const defaultStatDays = 30
+
+// статистика выдачи обновлений по каналам, версиям и дням
+func (p *Service) stats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := p.checkRights(r, entity.ScopeAdmin); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		filter, err := parseStatFilter(r)
+		if err != nil {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+			return
+		}
+
+		stats, err := p.stat.Stats(filter, r.Context())
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+
+		p.controller.RespondData(w, http.StatusOK, "application/json; charset=utf-8", stats)
+	}
+}
+
+// фильтр по каналу и периоду из параметров запроса: channel, from, to в формате 2006-01-02
+func parseStatFilter(r *http.Request) (entity.StatFilter, error) {
+	var err error
+	filter := entity.StatFilter{
+		Channel: r.URL.Query().Get("channel"),
+	}
+
+	if to := r.URL.Query().Get("to"); len(to) > 0 {
+		if filter.To, err = time.Parse("2006-01-02", to); err != nil {
+			return entity.StatFilter{}, err
+		}
+		filter.To = filter.To.AddDate(0, 0, 1) // включительно
+	} else {
+		filter.To = time.Now()
+	}
+
+	if from := r.URL.Query().Get("from"); len(from) > 0 {
+		if filter.From, err = time.Parse("2006-01-02", from); err != nil {
+			return entity.StatFilter{}, err
+		}
+	} else {
+		filter.From = filter.To.AddDate(0, 0, -defaultStatDays)
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,394 @@
+package presenter
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/n-r-w/eno"
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/updsrv/internal/config"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// размер значения генерируемого токена в байтах
+const tokenSecretSize = 32
+
+// tokenStore хранилище токенов доступа: токены из конфига и из БД. Ключ - хэш значения токена
+type tokenStore struct {
+	mutex  sync.RWMutex
+	config map[string]*entity.Token
+	db     map[string]*entity.Token
+	certs  map[string]*entity.Token // права по клиентским сертификатам. Ключ - subject или CN
+}
+
+func newTokenStore(cfg *config.Config) *tokenStore {
+	s := &tokenStore{
+		db: map[string]*entity.Token{},
+	}
+	s.setConfig(cfg)
+	return s
+}
+
+// setConfig замена токенов из конфига и прав по клиентским сертификатам
+func (s *tokenStore) setConfig(cfg *config.Config) {
+	certs := map[string]*entity.Token{}
+	for subject, c := range cfg.ClientCerts {
+		certs[subject] = &entity.Token{
+			Name:     "cert:" + subject,
+			Scopes:   c.Scopes,
+			Channels: c.Channels,
+		}
+	}
+
+	tokens := map[string]*entity.Token{}
+	for scope, values := range map[string][]string{
+		entity.ScopeRead:  cfg.TokensRead,
+		entity.ScopeWrite: cfg.TokensWrite,
+		entity.ScopeAdmin: cfg.TokensAdmin,
+	} {
+		for _, v := range values {
+			hash := tokenHash(v)
+			t := tokens[hash]
+			if t == nil {
+				t = &entity.Token{
+					Name:       "config:" + tokenName(v),
+					SecretHash: hash,
+				}
+				tokens[hash] = t
+			}
+			t.Scopes = append(t.Scopes, scope)
+		}
+	}
+
+	s.mutex.Lock()
+	s.config = tokens
+	s.certs = certs
+	s.mutex.Unlock()
+}
+
+// withConfig новое хранилище с токенами из cfg и текущими токенами из БД. Для проверки конфига до применения
+func (s *tokenStore) withConfig(cfg *config.Config) *tokenStore {
+	res := newTokenStore(cfg)
+
+	s.mutex.RLock()
+	res.db = s.db
+	s.mutex.RUnlock()
+
+	return res
+}
+
+// validateTokenConfig проверка токенов и прав по клиентским сертификатам из конфига
+func validateTokenConfig(cfg *config.Config) error {
+	for name, values := range map[string][]string{
+		"TOKENS_READ":  cfg.TokensRead,
+		"TOKENS_WRITE": cfg.TokensWrite,
+		"TOKENS_ADMIN": cfg.TokensAdmin,
+	} {
+		for _, v := range values {
+			if len(strings.TrimSpace(v)) == 0 {
+				return nerr.NewFmt("%s: empty token", name)
+			}
+		}
+	}
+
+	for subject, c := range cfg.ClientCerts {
+		if len(subject) == 0 {
+			return nerr.New("TLS_CLIENT_CERTS: empty subject")
+		}
+		if len(c.Scopes) == 0 {
+			return nerr.NewFmt("TLS_CLIENT_CERTS %s: no scopes", subject)
+		}
+		for _, scope := range c.Scopes {
+			if !entity.ValidScope(scope) {
+				return nerr.NewFmt("TLS_CLIENT_CERTS %s: invalid scope: %s", subject, scope)
+			}
+		}
+		for _, channel := range c.Channels {
+			if !entity.ValidChannelPattern(channel) {
+				return nerr.NewFmt("TLS_CLIENT_CERTS %s: invalid channel: %s", subject, channel)
+			}
+		}
+	}
+
+	return nil
+}
+
+// get действующий токен по значению. nil, если не найден, отозван или просрочен
+func (s *tokenStore) get(secret string) *entity.Token {
+	if len(secret) == 0 {
+		return nil
+	}
+
+	hash := tokenHash(secret)
+
+	s.mutex.RLock()
+	t := s.db[hash]
+	if t == nil {
+		t = s.config[hash]
+	}
+	s.mutex.RUnlock()
+
+	if t == nil || !t.Valid(time.Now()) {
+		return nil
+	}
+	return t
+}
+
+// getCert права по клиентскому сертификату. nil, если сертификат не зарегистрирован
+func (s *tokenStore) getCert(cert *x509.Certificate) *entity.Token {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if t := s.certs[cert.Subject.String()]; t != nil {
+		return t
+	}
+	return s.certs[cert.Subject.CommonName]
+}
+
+// setDB замена токенов из БД
+func (s *tokenStore) setDB(tokens []entity.Token) {
+	db := map[string]*entity.Token{}
+	for i := range tokens {
+		db[tokens[i].SecretHash] = &tokens[i]
+	}
+
+	s.mutex.Lock()
+	s.db = db
+	s.mutex.Unlock()
+}
+
+// hasScope есть ли хоть один действующий токен с указанным правом
+func (s *tokenStore) hasScope(scope string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	for _, m := range []map[string]*entity.Token{s.config, s.db, s.certs} {
+		for _, t := range m {
+			if t.Valid(now) && t.HasScope(scope) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// загрузка токенов из БД
+func (p *Service) reloadTokens(ctx context.Context) error {
+	tokens, err := p.tokenRepo.Tokens(ctx)
+	if err != nil {
+		return err
+	}
+	p.tokens.setDB(tokens)
+	return nil
+}
+
+// периодическая загрузка токенов из БД, чтобы изменения на других экземплярах сервера применялись и здесь
+func (p *Service) refreshTokens() {
+	ticker := time.NewTicker(time.Second * time.Duration(p.config.TokensRefresh))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(p.config.DbReadTimeout))
+		if err := p.reloadTokens(ctx); err != nil {
+			p.logger.Error("reload tokens: %v", err)
+		}
+		cancel()
+	}
+}
+
+// список токенов доступа
+func (p *Service) tokenList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := p.checkRights(r, entity.ScopeAdmin); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		tokens, err := p.tokenRepo.Tokens(r.Context())
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+
+		p.controller.RespondData(w, http.StatusOK, "application/json; charset=utf-8", tokens)
+	}
+}
+
+// запрос на операцию с токеном
+type tokenRequest struct {
+	Name       string    `json:"name"`
+	Scopes     []string  `json:"scopes,omitempty"`
+	Channels   []string  `json:"channels,omitempty"`
+	ExpireTime time.Time `json:"expireTime,omitempty"`
+}
+
+// создать токен доступа
+func (p *Service) tokenCreate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := p.checkRights(r, entity.ScopeAdmin); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		var req tokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+			return
+		}
+		auditParam(r, "name", req.Name)
+		auditParam(r, "scopes", entity.ListString(req.Scopes))
+		auditParam(r, "channels", entity.ListString(req.Channels))
+		if !req.ExpireTime.IsZero() {
+			auditParam(r, "expireTime", req.ExpireTime.Format(time.RFC3339))
+		}
+
+		// имена с ':' зарезервированы за токенами сервера: config:, cert:, jwt:
+		if len(req.Name) == 0 || strings.Contains(req.Name, ":") {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.NewFmt("invalid name: '%s'", req.Name))
+			return
+		}
+		if len(req.Scopes) == 0 {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New("no scopes"))
+			return
+		}
+		for _, s := range req.Scopes {
+			if !entity.ValidScope(s) {
+				p.controller.RespondError(w, http.StatusBadRequest, nerr.NewFmt("invalid scope: %s", s))
+				return
+			}
+		}
+		for _, c := range req.Channels {
+			if !entity.ValidChannelPattern(c) || strings.Contains(c, ",") {
+				p.controller.RespondError(w, http.StatusBadRequest, nerr.NewFmt("invalid channel: %s", c))
+				return
+			}
+		}
+
+		secret, err := newTokenSecret()
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+
+		token, err := p.tokenRepo.CreateToken(entity.Token{
+			Name:       req.Name,
+			Scopes:     req.Scopes,
+			Channels:   req.Channels,
+			ExpireTime: req.ExpireTime,
+			SecretHash: tokenHash(secret),
+		}, r.Context())
+		if errors.Is(err, eno.ErrObjectExist) {
+			p.controller.RespondError(w, http.StatusConflict, nerr.NewFmt("token already exists: %s", req.Name))
+			return
+		}
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+
+		if err := p.reloadTokens(r.Context()); err != nil {
+			p.logger.Error("reload tokens: %v", err)
+		}
+
+		p.controller.RespondData(w, http.StatusCreated, "application/json; charset=utf-8",
+			entity.TokenSecret{Token: token, Secret: secret})
+	}
+}
+
+// сменить значение токена доступа
+func (p *Service) tokenRotate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := p.checkRights(r, entity.ScopeAdmin); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		var req tokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+			return
+		}
+		auditParam(r, "name", req.Name)
+
+		secret, err := newTokenSecret()
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+
+		found, token, err := p.tokenRepo.RotateToken(req.Name, tokenHash(secret), r.Context())
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+		if !found {
+			p.controller.RespondError(w, http.StatusNotFound, nerr.NewFmt("token not found: %s", req.Name))
+			return
+		}
+
+		if err := p.reloadTokens(r.Context()); err != nil {
+			p.logger.Error("reload tokens: %v", err)
+		}
+
+		p.controller.RespondData(w, http.StatusOK, "application/json; charset=utf-8",
+			entity.TokenSecret{Token: token, Secret: secret})
+	}
+}
+
+// отозвать токен доступа
+func (p *Service) tokenRevoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := p.checkRights(r, entity.ScopeAdmin); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		var req tokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+			return
+		}
+		auditParam(r, "name", req.Name)
+
+		found, err := p.tokenRepo.RevokeToken(req.Name, r.Context())
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+		if !found {
+			p.controller.RespondError(w, http.StatusNotFound, nerr.NewFmt("token not found: %s", req.Name))
+			return
+		}
+
+		if err := p.reloadTokens(r.Context()); err != nil {
+			p.logger.Error("reload tokens: %v", err)
+		}
+
+		p.controller.RespondData(w, http.StatusOK, "application/json; charset=utf-8", nil)
+	}
+}
+
+// генерация значения нового токена
+func newTokenSecret() (string, error) {
+	b := make([]byte, tokenSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// хэш значения токена для хранения
+func tokenHash(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
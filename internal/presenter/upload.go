@@ -0,0 +1,334 @@
+package presenter
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n-r-w/eno"
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// размер идентификатора сессии загрузки в байтах
+const uploadIDSize = 16
+
+// как часто удаляются истекшие сессии загрузки
+const uploadExpireInterval = time.Minute
+
+// создать сессию загрузки обновления частями. Параметры версии как у /api/add, дополнительно size и checksum архива
+func (p *Service) uploadCreate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := p.checkRights(r, entity.ScopeWrite); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		info, err := updateInfoFromForm(r)
+		if err != nil {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+			return
+		}
+		if err := p.checkRights(r, entity.ScopeWrite, info.Channel); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		session := entity.UploadSession{
+			Token:     entity.GetClientInfoFromContext(r.Context()).Token,
+			Channel:   info.Channel,
+			Version:   info.Version,
+			Info:      info.Info,
+			Enabled:   info.Enabled,
+			BuildTime: info.BuildTime,
+			Checksum:  strings.ToLower(r.FormValue("checksum")),
+		}
+
+		if size := r.FormValue("size"); len(size) > 0 {
+			if session.Size, err = strconv.ParseInt(size, 10, 64); err != nil || session.Size < 0 {
+				p.controller.RespondError(w, http.StatusBadRequest, nerr.NewFmt("invalid size: %s", size))
+				return
+			}
+		}
+		if session.Size > p.maxUploadSize() {
+			p.controller.RespondError(w, http.StatusRequestEntityTooLarge, nerr.NewFmt("size too large, max %d", p.maxUploadSize()))
+			return
+		}
+		if len(session.Checksum) > 0 && !validSha256(session.Checksum) {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.NewFmt("invalid checksum: %s", session.Checksum))
+			return
+		}
+
+		if session.ID, err = newUploadID(); err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+		session.CreateTime = time.Now()
+		session.ExpireTime = session.CreateTime.Add(p.uploadTTL())
+
+		if err := p.uploads.CreateUpload(session, r.Context()); err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+
+		p.controller.RespondData(w, http.StatusCreated, "application/json; charset=utf-8", session)
+	}
+}
+
+// загрузить часть архива: id - сессия, number - номер части с 0, sha256 части в заголовке X-Chunk-Checksum.
+// Повторная загрузка части с тем же номером заменяет ее
+func (p *Service) uploadChunk() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := p.uploadSession(w, r)
+		if !ok {
+			return
+		}
+
+		number, err := strconv.Atoi(r.URL.Query().Get("number"))
+		if err != nil || number < 0 {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.NewFmt("invalid number: %s", r.URL.Query().Get("number")))
+			return
+		}
+
+		checksum := strings.ToLower(r.Header.Get("X-Chunk-Checksum"))
+		if !validSha256(checksum) {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New("X-Chunk-Checksum: sha256 of chunk required"))
+			return
+		}
+
+		maxChunk := int64(p.config.UploadChunkSize) << 20
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxChunk))
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				p.controller.RespondError(w, http.StatusRequestEntityTooLarge, nerr.NewFmt("chunk too large, max %d", maxChunk))
+				return
+			}
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+			return
+		}
+		if len(data) == 0 {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New("empty chunk"))
+			return
+		}
+
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != checksum {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.NewFmt("chunk %d checksum mismatch", number))
+			return
+		}
+
+		chunk := entity.UploadChunk{
+			Number:   number,
+			Size:     int64(len(data)),
+			Checksum: checksum,
+		}
+		// размер проверяется в одной транзакции с сохранением, чтобы параллельные части не превысили лимит
+		accepted, total, err := p.uploads.AddUploadChunk(session.ID, chunk, data, p.maxUploadSize(), time.Now().Add(p.uploadTTL()), r.Context())
+		if errors.Is(err, eno.ErrNotFound) {
+			p.controller.RespondError(w, http.StatusNotFound, nerr.New("upload session not found"))
+			return
+		}
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+		if !accepted {
+			p.controller.RespondError(w, http.StatusRequestEntityTooLarge, nerr.NewFmt("upload size %d exceeds limit", total))
+			return
+		}
+
+		p.respondUploadStatus(w, r, session.ID)
+	}
+}
+
+// состояние сессии загрузки: полученные части, received - сколько байт получено подряд с начала архива
+func (p *Service) uploadStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := p.uploadSession(w, r)
+		if !ok {
+			return
+		}
+
+		p.controller.RespondData(w, http.StatusOK, "application/json; charset=utf-8", session)
+	}
+}
+
+// завершить загрузку: архив собирается из частей и публикуется как при /api/add. После публикации сессия удаляется
+func (p *Service) uploadFinalize() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := p.uploadSession(w, r)
+		if !ok {
+			return
+		}
+		auditParam(r, "upload", session.ID)
+		auditParam(r, "channel", session.Channel)
+		auditParam(r, "version", session.Version.String())
+
+		// части должны идти подряд с 0
+		if session.NextChunk != len(session.Chunks) || len(session.Chunks) == 0 {
+			p.controller.RespondError(w, http.StatusConflict, nerr.NewFmt("chunk %d missing", session.NextChunk))
+			return
+		}
+		if session.Size > 0 && session.Received != session.Size {
+			p.controller.RespondError(w, http.StatusConflict, nerr.NewFmt("received %d of %d bytes", session.Received, session.Size))
+			return
+		}
+
+		// собираем архив во временном файле
+		file, err := ioutil.TempFile("", "upsrvupload")
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		hash := sha256.New()
+		if err := p.uploads.ReadUpload(session, io.MultiWriter(file, hash), r.Context()); err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+		if sum := hex.EncodeToString(hash.Sum(nil)); len(session.Checksum) > 0 && sum != session.Checksum {
+			p.controller.RespondError(w, http.StatusConflict, nerr.NewFmt("archive checksum mismatch: %s", sum))
+			return
+		}
+
+		archive, err := detectArchive(file, session.Received, p.archiveLimits())
+		if err != nil {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New(err))
+			return
+		}
+
+		info := entity.UpdateInfo{
+			Channel:   session.Channel,
+			Version:   session.Version,
+			Info:      session.Info,
+			Enabled:   session.Enabled,
+			BuildTime: session.BuildTime,
+		}
+		if !p.publish(w, r, archive, &info, publishOptions{}) {
+			return
+		}
+
+		// версия уже добавлена, ошибку удаления сессии клиенту не возвращаем: сессия истечет сама
+		if _, err := p.uploads.DeleteUpload(session.ID, r.Context()); err != nil {
+			p.logger.Error("delete upload %s: %v", session.ID, err)
+		}
+	}
+}
+
+// отменить загрузку и удалить полученные части
+func (p *Service) uploadCancel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := p.uploadSession(w, r)
+		if !ok {
+			return
+		}
+
+		if _, err := p.uploads.DeleteUpload(session.ID, r.Context()); err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+
+		p.controller.RespondData(w, http.StatusOK, "application/json; charset=utf-8", nil)
+	}
+}
+
+// uploadSession сессия загрузки из параметра id с проверкой прав. Если false, то ответ с ошибкой уже отправлен
+func (p *Service) uploadSession(w http.ResponseWriter, r *http.Request) (entity.UploadSession, bool) {
+	if err := p.checkRights(r, entity.ScopeWrite); err != nil {
+		p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+		return entity.UploadSession{}, false
+	}
+
+	id := r.URL.Query().Get("id")
+	if len(id) == 0 {
+		p.controller.RespondError(w, http.StatusBadRequest, nerr.New("no id"))
+		return entity.UploadSession{}, false
+	}
+
+	found, session, err := p.uploads.Upload(id, entity.GetClientInfoFromContext(r.Context()).Token, r.Context())
+	if err != nil {
+		p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+		return entity.UploadSession{}, false
+	}
+	if !found {
+		p.controller.RespondError(w, http.StatusNotFound, nerr.New("upload session not found"))
+		return entity.UploadSession{}, false
+	}
+
+	// права токена на канал могли измениться после создания сессии
+	if err := p.checkRights(r, entity.ScopeWrite, session.Channel); err != nil {
+		p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+		return entity.UploadSession{}, false
+	}
+
+	return session, true
+}
+
+func (p *Service) respondUploadStatus(w http.ResponseWriter, r *http.Request, id string) {
+	found, session, err := p.uploads.Upload(id, entity.GetClientInfoFromContext(r.Context()).Token, r.Context())
+	if err != nil {
+		p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+		return
+	}
+	if !found {
+		p.controller.RespondError(w, http.StatusNotFound, nerr.New("upload session not found"))
+		return
+	}
+
+	p.controller.RespondData(w, http.StatusOK, "application/json; charset=utf-8", session)
+}
+
+// периодическое удаление брошенных сессий загрузки
+func (p *Service) expireUploads() {
+	ticker := time.NewTicker(uploadExpireInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(p.config.DbWriteTimeout))
+		count, err := p.uploads.ExpireUploads(ctx)
+		cancel()
+
+		if err != nil {
+			p.logger.Error("expire uploads: %v", err)
+		} else if count > 0 {
+			p.logger.Info("expired upload sessions: %d", count)
+		}
+	}
+}
+
+func (p *Service) maxUploadSize() int64 {
+	return int64(p.config.MaxUploadSize) << 20
+}
+
+func (p *Service) uploadTTL() time.Duration {
+	return time.Minute * time.Duration(p.config.UploadSessionTTL)
+}
+
+// генерация идентификатора сессии загрузки
+func newUploadID() (string, error) {
+	b := make([]byte, uploadIDSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validSha256 проверка формата sha256 в hex
+func validSha256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
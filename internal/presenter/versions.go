@@ -0,0 +1,31 @@
+package presenter
+
+import (
+	"net/http"
+
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// список версий канала
+func (p *Service) versions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		channel := r.URL.Query().Get("channel")
+		if len(channel) == 0 {
+			p.controller.RespondError(w, http.StatusBadRequest, nerr.New("no channel"))
+			return
+		}
+		if err := p.checkRights(r, entity.ScopeRead, channel); err != nil {
+			p.controller.RespondError(w, http.StatusForbidden, nerr.New(err))
+			return
+		}
+
+		res, err := p.repo.Versions(channel, r.Context())
+		if err != nil {
+			p.controller.RespondError(w, http.StatusInternalServerError, nerr.New(err))
+			return
+		}
+
+		p.controller.RespondData(w, http.StatusOK, "application/json; charset=utf-8", res)
+	}
+}
@@ -2,7 +2,10 @@ package psql
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -17,15 +20,37 @@ import (
 )
 
 // Add добавить обновление
-func (p *Repo) Add(ui *entity.UpdateInfo, ctx context.Context) error {
+func (p *Repo) Add(ui *entity.UpdateInfo, ctx context.Context) (entity.PublishReceipt, error) {
 	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbWriteTimeout))
 	defer cancel()
 
 	p.logOp(ctx, lg.Info, "request to add a new version: %s, %s", ui.Channel, ui.Version.String())
 
+	receipt := entity.PublishReceipt{
+		Channel:           ui.Channel,
+		Version:           ui.Version,
+		NormalizedVersion: ui.Version.String(),
+		FileCount:         len(ui.Files),
+		ManifestHash:      manifestHash(ui.Files),
+	}
+	contents := map[string]bool{}
+	for _, fi := range ui.Files {
+		receipt.TotalSize += fi.Size
+		if !contents[fi.Checksum] {
+			contents[fi.Checksum] = true
+			receipt.UniqueContentSize += fi.Size
+		}
+	}
+
+	// последняя версия до публикации, с нее клиенты будут обновляться на новую
+	prevFound, prev, err := p.getUpdateInfo(ui.Channel, entity.Version{}, true, ctxChild)
+	if err != nil {
+		return entity.PublishReceipt{}, err
+	}
+
 	tx := sqlq.NewTx(p.Pool, ctxChild)
 	if err := tx.Begin(); err != nil {
-		return err
+		return entity.PublishReceipt{}, err
 	}
 	defer tx.Rollback()
 
@@ -44,53 +69,79 @@ func (p *Repo) Add(ui *entity.UpdateInfo, ctx context.Context) error {
 			"enabled":    ui.Enabled,
 		}, "add")
 	if err != nil {
-		return err
+		return entity.PublishReceipt{}, err
 	}
 
 	q, err := sqlq.SelectTxRow(tx, sql)
 	if err != nil {
 		if nerr.SqlCode(err) == pgerrcode.UniqueViolation {
 			// такое обновление уже есть
-			return nerr.New(eno.ErrObjectExist)
+			return entity.PublishReceipt{}, nerr.New(eno.ErrObjectExist)
 		}
-		return nerr.New(err, tools.SimplifyString(sql))
+		return entity.PublishReceipt{}, nerr.New(err, tools.SimplifyString(sql))
 	}
 	idUpdate := q.UInt64("id")
 
 	// сначала сохраняем содержимое файлов
 	var fileOids []uint32
+	var sharedOids []uint32
 	for i, fi := range ui.Files {
+		if fi.Data == nil && fi.DataID != 0 {
+			// содержимое файла другой версии, large object становится общим
+			fileOids = append(fileOids, fi.DataID)
+			sharedOids = append(sharedOids, fi.DataID)
+			continue
+		}
+
 		oid, err := sqlq.SaveLargeObject(tx, 0, fi.Data)
 		if err != nil {
-			return err
+			return entity.PublishReceipt{}, err
 		}
 		fileOids = append(fileOids, oid)
 		ui.Files[i].Data = nil // для экономии памяти
 	}
 
+	if err = lockSharedData(tx, sharedOids); err != nil {
+		return entity.PublishReceipt{}, err
+	}
+
 	// затем информацию о файлах
 	var filesSql []string
 	for i, fi := range ui.Files {
-		fsql, err := sqlb.Bind("(:id_update, :file_name, :checksum, :data_oid)",
+		var modUnix int64
+		if !fi.ModTime.IsZero() {
+			modUnix = fi.ModTime.Unix()
+		}
+
+		fsql, err := sqlb.Bind(`(:id_update, :file_name, :checksum, :data_oid, :mode,
+				CASE WHEN :mod_unix = 0 THEN NULL ELSE to_timestamp(:mod_unix) END, :link_target, :size)`,
 			map[string]interface{}{
-				"id_update": idUpdate,
-				"file_name": fi.Name,
-				"checksum":  fi.Checksum,
-				"data_oid":  fileOids[i],
+				"id_update":   idUpdate,
+				"file_name":   fi.Name,
+				"checksum":    fi.Checksum,
+				"data_oid":    fileOids[i],
+				"mode":        fi.Mode,
+				"mod_unix":    modUnix,
+				"link_target": fi.LinkTarget,
+				"size":        fi.Size,
 			},
 			"files")
 		if err != nil {
-			return err
+			return entity.PublishReceipt{}, err
 		}
 		filesSql = append(filesSql, fsql)
 	}
 
-	sql = fmt.Sprintf(`INSERT INTO public.files(id_update, file_name, checksum, data_oid) VALUES %s`, strings.Join(filesSql, ","))
-	if _, err := sqlq.ExecTx(tx, sql); err != nil {
-		return nerr.New(err, tools.SimplifyString(sql))
+	// версия может быть пустой, если в режиме наложения удалены все файлы
+	if len(filesSql) > 0 {
+		sql = fmt.Sprintf(`INSERT INTO public.files(id_update, file_name, checksum, data_oid, mode, mod_time, link_target, size) VALUES %s`, strings.Join(filesSql, ","))
+		if _, err := sqlq.ExecTx(tx, sql); err != nil {
+			return entity.PublishReceipt{}, nerr.New(err, tools.SimplifyString(sql))
+		}
 	}
 
 	// удаляем старые версии
+	live := p.liveConfig()
 	sql, err = sqlb.Bind(
 		`WITH deleted AS (
 		DELETE FROM updates 
@@ -110,14 +161,14 @@ func (p *Repo) Add(ui *entity.UpdateInfo, ctx context.Context) error {
 		GROUP BY major, minor, patch, revision`,
 		map[string]interface{}{
 			"channel":   ui.Channel,
-			"max_count": p.config.MaxVersionCount,
-			"min_days":  p.config.MinVersionAge,
+			"max_count": live.MaxVersionCount,
+			"min_days":  live.MinVersionAge,
 		}, "DeleteOld")
 	if err != nil {
-		return err
+		return entity.PublishReceipt{}, err
 	}
 	if q, err = sqlq.SelectTx(tx, sql); err != nil {
-		return nerr.New(err, tools.SimplifyString(sql))
+		return entity.PublishReceipt{}, nerr.New(err, tools.SimplifyString(sql))
 	}
 
 	deletedVersions := []entity.Version{}
@@ -140,9 +191,90 @@ func (p *Repo) Add(ui *entity.UpdateInfo, ctx context.Context) error {
 		p.logOp(ctx, lg.Info, "%s, old versions deleted: %s", ui.Channel, delInfo)
 	}
 
-	if err = tx.Commit(); err == nil {
-		p.logOp(ctx, lg.Info, "new version added: %s, %s", ui.Channel, ui.Version.String())
+	if err = tx.Commit(); err != nil {
+		return entity.PublishReceipt{}, err
+	}
+	p.logOp(ctx, lg.Info, "new version added: %s, %s", ui.Channel, ui.Version.String())
+
+	receipt.ID = idUpdate
+	receipt.Deleted = deletedVersions
+	receipt.WarmupScheduled = p.scheduleWarmup(ui, idUpdate, prevFound, prev, ctx)
+
+	return receipt, nil
+}
+
+// lockSharedData блокировка общих large object до конца транзакции, чтобы триггер t_files_clear_data
+// не удалил их вместе с базовой версией. Если базовую версию уже удалили, то возвращает ошибку
+func lockSharedData(tx *sqlq.Tx, oids []uint32) error {
+	if len(oids) == 0 {
+		return nil
+	}
+
+	// одинаковый порядок блокировок в параллельных транзакциях
+	sorted := append([]uint32(nil), oids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var keys []string
+	for i, oid := range sorted {
+		if i == 0 || oid != sorted[i-1] {
+			keys = append(keys, fmt.Sprint(oid))
+		}
+	}
+	list := strings.Join(keys, ",")
+
+	sql := fmt.Sprintf(`SELECT pg_advisory_xact_lock_shared(o) FROM unnest(ARRAY[%s]::bigint[]) AS o`, list)
+	if _, err := sqlq.ExecTx(tx, sql); err != nil {
+		return nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	sql = fmt.Sprintf(`SELECT count(*) AS n FROM pg_largeobject_metadata WHERE oid IN (%s)`, list)
+	q, err := sqlq.SelectTxRow(tx, sql)
+	if err != nil {
+		return nerr.New(err, tools.SimplifyString(sql))
+	}
+	if q == nil || q.Int("n") != len(keys) {
+		return nerr.New("base version was deleted during publication")
+	}
+
+	return nil
+}
+
+// scheduleWarmup запуск в фоне подготовки разницы между предыдущей последней версией и новой, если клиенты
+// предыдущей версии будут получать новую. Ошибки не влияют на публикацию, только логируются
+func (p *Repo) scheduleWarmup(ui *entity.UpdateInfo, idUpdate uint64, prevFound bool, prev entity.UpdateInfo, ctx context.Context) bool {
+	if !ui.Enabled || !prevFound {
+		return false
+	}
+
+	found, next, err := p.getUpdateInfo(prev.Channel, prev.Version, true, ctx)
+	if err != nil {
+		p.logOp(ctx, lg.Warn, "diff warmup: %v", err)
+		return false
+	}
+	if !found || next.ID != idUpdate {
+		return false
+	}
+
+	// запрос завершится раньше подготовки, поэтому контекст отдельный
+	ci := *entity.GetClientInfoFromContext(ctx)
+	go p.cache.Warmup(processVersion{
+		fromC:  prev.Channel,
+		fromV:  prev.Version,
+		toC:    ui.Channel,
+		toV:    ui.Version,
+		format: entity.PackageZip,
+	}, &ci)
+
+	return true
+}
+
+// manifestHash sha256 списка файлов версии, отсортированного по имени
+func manifestHash(files []entity.FileInfo) string {
+	lines := make([]string, len(files))
+	for i, fi := range files {
+		lines[i] = fmt.Sprintf("%s\x00%s\x00%o\x00%s\n", fi.Name, fi.Checksum, fi.Mode, fi.LinkTarget)
 	}
+	sort.Strings(lines)
 
-	return err
+	sum := sha256.Sum256([]byte(strings.Join(lines, "")))
+	return hex.EncodeToString(sum[:])
 }
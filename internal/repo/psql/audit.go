@@ -0,0 +1,121 @@
+package psql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/sqlb"
+	"github.com/n-r-w/sqlq"
+	"github.com/n-r-w/tools"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// максимальное количество записей журнала в ответе по умолчанию
+const defaultAuditLimit = 1000
+
+// AddAudit добавить запись в журнал административных операций
+func (p *Repo) AddAudit(rec entity.AuditRecord, ctx context.Context) error {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbWriteTimeout))
+	defer cancel()
+
+	if rec.Params == nil {
+		rec.Params = map[string]string{}
+	}
+	params, err := json.Marshal(rec.Params)
+	if err != nil {
+		return nerr.New(err)
+	}
+
+	sql, err := sqlb.Bind(
+		`INSERT INTO public.audit(operation, token_name, ip, real_ip, channel, params, status, result)
+			VALUES (:operation, :token_name, :ip, :real_ip, :channel, :params::jsonb, :status, :result)`,
+		map[string]interface{}{
+			"operation":  rec.Operation,
+			"token_name": rec.Token,
+			"ip":         rec.IP,
+			"real_ip":    rec.RealIP,
+			"channel":    rec.Channel,
+			"params":     string(params),
+			"status":     rec.Status,
+			"result":     rec.Result,
+		}, "AddAudit")
+	if err != nil {
+		return err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = sqlq.ExecTx(tx, sql); err != nil {
+		return nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	return tx.Commit()
+}
+
+// Audit журнал административных операций по фильтру
+func (p *Repo) Audit(filter entity.AuditFilter, ctx context.Context) ([]entity.AuditRecord, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbReadTimeout))
+	defer cancel()
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditLimit
+	}
+
+	sql, err := sqlb.Bind(
+		`SELECT id, record_time, operation, token_name, COALESCE(ip, '') AS ip, COALESCE(real_ip, '') AS real_ip,
+			COALESCE(channel, '') AS channel, params::text AS params, status, COALESCE(result, '') AS result
+		FROM audit
+		WHERE record_time >= :from AND record_time < :to
+			AND (:channel = '' OR channel = :channel)
+			AND (:operation = '' OR operation = :operation)
+		ORDER BY record_time DESC
+		LIMIT :limit`,
+		map[string]interface{}{
+			"from":      filter.From,
+			"to":        filter.To,
+			"channel":   filter.Channel,
+			"operation": filter.Operation,
+			"limit":     filter.Limit,
+		}, "Audit")
+	if err != nil {
+		return nil, err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	q, err := sqlq.SelectTx(tx, sql)
+	if err != nil {
+		return nil, nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	res := []entity.AuditRecord{}
+	for q.Next() {
+		rec := entity.AuditRecord{
+			ID:        q.UInt64("id"),
+			Time:      q.Time("record_time"),
+			Operation: q.String("operation"),
+			Token:     q.String("token_name"),
+			IP:        q.String("ip"),
+			RealIP:    q.String("real_ip"),
+			Channel:   q.String("channel"),
+			Status:    q.Int("status"),
+			Result:    q.String("result"),
+		}
+		if err := json.Unmarshal(q.Bytes("params"), &rec.Params); err != nil {
+			return nil, nerr.New(err)
+		}
+		res = append(res, rec)
+	}
+
+	return res, nil
+}
@@ -1,7 +1,6 @@
 package psql
 
 import (
-	"archive/zip"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -21,14 +20,16 @@ import (
 )
 
 type processVersion struct {
-	fromC string
-	fromV entity.Version
-	toC   string
-	toV   entity.Version
+	fromC  string
+	fromV  entity.Version
+	toC    string
+	toV    entity.Version
+	format string // формат архива: entity.PackageZip, entity.PackageTarZstd
+	warmup bool   // подготовка заранее, без запроса клиента: не учитывается в событиях выдачи
 }
 
 func (v *processVersion) String() string {
-	return fmt.Sprintf("%s_%s_%s_%s", v.fromC, v.fromV.String(), v.toC, v.fromV.String())
+	return fmt.Sprintf("%s_%s_%s_%s_%s", v.fromC, v.fromV.String(), v.toC, v.toV.String(), v.format)
 }
 
 // Cache отвечает за получение обновлений из БД с использованием кэша
@@ -48,9 +49,18 @@ func NewCache(r *Repo) *Cache {
 	}
 }
 
+// setLimit изменение ограничения нагрузки на БД
+func (c *Cache) setLimit(limit int, burst int) {
+	c.limiter.SetLimit(rate.Limit(limit))
+	c.limiter.SetBurst(burst)
+}
+
 func (c *Cache) Get(v processVersion, ctx context.Context) (*entity.UpdateInfo, []byte, error) {
+	start := time.Now()
+
 	// Защита от DDOS и в целом от перегрузки сервера БД запросами
 	if !c.limiter.Allow() {
+		c.r.metrics.RateLimitRejections.WithLabelValues("update", "global").Inc()
 		return nil, nil, nerr.New(eno.ErrTooManyRequests)
 	}
 
@@ -66,29 +76,35 @@ func (c *Cache) Get(v processVersion, ctx context.Context) (*entity.UpdateInfo,
 	// ищем диф в БД, не блокируем других
 
 	// сначала смотрим в кэше прямое обновление
-	res, zipData, updateCache, err := c.askCache(v, ctx, true)
+	res, pkgData, updateCache, err := c.askCache(v, ctx, true)
 	if err != nil {
 		return nil, nil, nerr.New(err)
 	}
-	if zipData != nil {
+	if pkgData != nil {
+		c.r.metrics.CacheRequests.WithLabelValues("hit").Inc()
 		c.r.logOp(ctx, lg.Info, "diff from cache: %s, %s => %s", v.fromC, v.fromV.String(), v.toV.String())
-		return res, zipData, nil
+		c.addEvent(entity.EventCache, v, pkgData, start, ctx)
+		return res, pkgData, nil
 	}
 
 	if !updateCache {
 		// затем смотрим в кэше полное обновление
-		res, zipData, updateCache, err = c.askCache(v, ctx, false)
+		res, pkgData, updateCache, err = c.askCache(v, ctx, false)
 		if err != nil {
 			return nil, nil, nerr.New(err)
 		}
-		if zipData != nil {
+		if pkgData != nil {
+			c.r.metrics.CacheRequests.WithLabelValues("hit").Inc()
 			c.r.logOp(ctx, lg.Info, "full data from cache: %s, %s => %s", v.toC, v.fromV.String(), v.toV.String())
-			return res, zipData, nil
+			c.addEvent(entity.EventFull, v, pkgData, start, ctx)
+			return res, pkgData, nil
 		}
 	}
 
 	// начинаем готовить diff
+	c.r.metrics.CacheRequests.WithLabelValues("miss").Inc()
 	c.r.logOp(ctx, lg.Info, "calculating diff: %s, %s => %s", v.fromC, v.fromV.String(), v.toV.String())
+	buildStart := time.Now()
 
 	c.mutex.Lock()
 	counter := c.processing[v.String()]
@@ -150,12 +166,16 @@ func (c *Cache) Get(v processVersion, ctx context.Context) (*entity.UpdateInfo,
 	}
 
 	if fullUpdate {
-		// делаем полный zip
+		// делаем полный архив. Он кэшируется и выдается всем клиентам, поэтому From не заполняется
 		c.r.logOp(ctx, lg.Warn, "no diff found: %s, %s => %s", v.fromC, v.fromV.String(), v.toV.String())
-		zipData, err = c.createZip(res.Files, ctx)
+		pkgData, err = c.createPackage(v.format, entity.Manifest{
+			Full: true,
+			To:   entity.ManifestVersion{Channel: res.Channel, Version: res.Version},
+		}, res.Files, ctx)
 		if err != nil {
 			return nil, nil, nerr.New(err)
 		}
+		c.r.metrics.DiffDuration.WithLabelValues("full").Observe(time.Since(buildStart).Seconds())
 
 	} else {
 		// вычисляем дельту
@@ -163,28 +183,66 @@ func (c *Cache) Get(v processVersion, ctx context.Context) (*entity.UpdateInfo,
 		res.Enabled = true
 		res.Files = createDiff(fromI.Files, toI.Files)
 
-		// делаем zip
-		zipData, err = c.createZip(res.Files, ctx)
+		// делаем архив
+		pkgData, err = c.createPackage(v.format, entity.Manifest{
+			From: entity.ManifestVersion{Channel: fromI.Channel, Version: fromI.Version},
+			To:   entity.ManifestVersion{Channel: toI.Channel, Version: toI.Version},
+		}, res.Files, ctx)
 		if err != nil {
 			return nil, nil, nerr.New(err)
 		}
+		c.r.metrics.DiffDuration.WithLabelValues("diff").Observe(time.Since(buildStart).Seconds())
 	}
 
 	// сохраняем кэш в БД
-	if err = c.save(updateCache, fromI, toI, res, zipData, ctx); err != nil {
+	if err = c.save(updateCache, v.format, fromI, toI, res, pkgData, ctx); err != nil {
 		return nil, nil, nerr.New(err)
 	}
 
-	return res, zipData, nil
+	if fullUpdate {
+		c.addEvent(entity.EventFull, v, pkgData, start, ctx)
+	} else {
+		c.addEvent(entity.EventDiff, v, pkgData, start, ctx)
+	}
+
+	return res, pkgData, nil
+}
+
+// Warmup подготовка разницы между версиями заранее, чтобы первый клиент не ждал ее построения.
+// Выполняется в фоне, ошибки только логируются
+func (c *Cache) Warmup(v processVersion, ci *entity.ClientInfo) {
+	ctx, cancel := context.WithTimeout(entity.PutClientInfoToContext(ci, context.Background()),
+		time.Second*time.Duration(c.r.config.DbWriteTimeout))
+	defer cancel()
+
+	v.warmup = true
+	if _, _, err := c.Get(v, ctx); err != nil {
+		c.r.logOp(ctx, lg.Warn, "diff warmup failed: %s, %s => %s: %v", v.fromC, v.fromV.String(), v.toV.String(), err)
+	}
+}
+
+// сохранить событие выдачи обновления
+func (c *Cache) addEvent(event string, v processVersion, pkgData []byte, start time.Time, ctx context.Context) {
+	if v.warmup {
+		return
+	}
+	c.r.addEvent(ctx, entity.Event{
+		Event:    event,
+		Channel:  v.fromC,
+		From:     v.fromV,
+		To:       v.toV,
+		Bytes:    len(pkgData),
+		Duration: time.Since(start),
+	})
 }
 
 // сохранить кэш в БД
-func (c *Cache) save(updateCache bool, fromI entity.UpdateInfo, toI entity.UpdateInfo, res *entity.UpdateInfo, zipData []byte, ctx context.Context) error {
+func (c *Cache) save(updateCache bool, format string, fromI entity.UpdateInfo, toI entity.UpdateInfo, res *entity.UpdateInfo, pkgData []byte, ctx context.Context) error {
 	tx := sqlq.NewTx(c.r.Pool, ctx)
 	tx.Begin()
 	defer tx.Rollback()
 
-	oid, err := sqlq.SaveLargeObject(tx, 0, zipData)
+	oid, err := sqlq.SaveLargeObject(tx, 0, pkgData)
 	if err != nil {
 		return nerr.New(err)
 	}
@@ -197,12 +255,14 @@ func (c *Cache) save(updateCache bool, fromI entity.UpdateInfo, toI entity.Updat
 	var sql string
 	if updateCache {
 		sql, err = sqlb.Bind(
-			`UPDATE cache SET diff_oid = :diff_oid, diff_info = :diff_info
-			WHERE id_update_from = :id_update_from AND id_update_to = :id_update_to
+			`UPDATE cache SET diff_oid = :diff_oid, diff_info = :diff_info, compression = :compression
+			WHERE id_update_from IS NOT DISTINCT FROM :id_update_from AND id_update_to = :id_update_to AND format = :format
 			`,
 			map[string]interface{}{
 				"id_update_from": sqlb.VNull(fromI.ID),
 				"id_update_to":   toI.ID,
+				"format":         format,
+				"compression":    c.compressionKey(toI.Channel),
 				"diff_oid":       oid,
 				"diff_info":      string(jsinfo),
 			}, "UpdateCache")
@@ -211,10 +271,13 @@ func (c *Cache) save(updateCache bool, fromI entity.UpdateInfo, toI entity.Updat
 		}
 
 	} else {
-		sql, err = sqlb.Bind(`INSERT INTO cache(id_update_from, id_update_to, diff_oid, diff_info) VALUES (:id_update_from, :id_update_to, :diff_oid, :diff_info)`,
+		sql, err = sqlb.Bind(`INSERT INTO cache(id_update_from, id_update_to, format, compression, diff_oid, diff_info)
+			VALUES (:id_update_from, :id_update_to, :format, :compression, :diff_oid, :diff_info)`,
 			map[string]interface{}{
 				"id_update_from": sqlb.VNull(fromI.ID),
 				"id_update_to":   toI.ID,
+				"format":         format,
+				"compression":    c.compressionKey(toI.Channel),
 				"diff_oid":       oid,
 				"diff_info":      string(jsinfo),
 			}, "UpdateCache")
@@ -248,6 +311,8 @@ func (c *Cache) waitReady(v processVersion, ctx context.Context) error {
 			if !wasWarn {
 				wasWarn = true
 				c.r.logOp(ctx, lg.Info, "waiting calculating diff: %s, %s => %s", v.fromC, v.fromV.String(), v.toV.String())
+				c.r.metrics.DiffWaiters.Inc()
+				defer c.r.metrics.DiffWaiters.Dec()
 			}
 
 			select {
@@ -266,16 +331,16 @@ func (c *Cache) waitReady(v processVersion, ctx context.Context) error {
 
 func (c *Cache) askCache(v processVersion, ctx context.Context,
 	// если истина, то ищет точно обновление, иначе ищет полный апдейт
-	direct bool) (res *entity.UpdateInfo, zipData []byte, updateCache bool, err error) {
+	direct bool) (res *entity.UpdateInfo, pkgData []byte, updateCache bool, err error) {
 
 	var sql string
 	res = &entity.UpdateInfo{}
 
 	if direct {
 		sql, err = sqlb.Bind(
-			`SELECT c.diff_oid, c.diff_info::text   
+			`SELECT c.diff_oid, c.diff_info::text, c.compression
 		FROM cache c   
-		WHERE 
+		WHERE c.format = :format AND
 			EXISTS(    
 				SELECT * FROM updates u    
 				WHERE u.channel = :channel AND 				
@@ -295,13 +360,14 @@ func (c *Cache) askCache(v processVersion, ctx context.Context,
 				"to_minor":      v.toV.Minor,
 				"to_patch":      v.toV.Patch,
 				"to_revision":   v.toV.Revision,
+				"format":        v.format,
 			}, "GetCacheDirect")
 
 	} else {
 		sql, err = sqlb.Bind(
-			`SELECT c.diff_oid, c.diff_info::text   
+			`SELECT c.diff_oid, c.diff_info::text, c.compression
 		FROM cache c   
-		WHERE 
+		WHERE c.format = :format AND
 			EXISTS(    
 				SELECT * FROM updates u    
 				WHERE u.channel = :channel AND c.id_update_from IS NULL AND
@@ -325,6 +391,7 @@ func (c *Cache) askCache(v processVersion, ctx context.Context,
 				"to_minor":      v.toV.Minor,
 				"to_patch":      v.toV.Patch,
 				"to_revision":   v.toV.Revision,
+				"format":        v.format,
 			}, "GetCacheFull")
 	}
 	if err != nil {
@@ -335,20 +402,25 @@ func (c *Cache) askCache(v processVersion, ctx context.Context,
 		return nil, nil, false, nerr.New(err)
 	}
 
+	if q != nil && q.String("compression") != c.compressionKey(v.toC) {
+		// архив собран с прежними настройками сжатия, пересобираем
+		return nil, nil, true, nil
+	}
+
 	if q != nil {
 		// найдено в кэше
 		if err = json.Unmarshal(q.Bytes("diff_info"), res); err == nil {
-			// извлекаем zip
+			// извлекаем архив
 			tx := sqlq.NewTx(c.r.Pool, ctx)
 			defer tx.Rollback()
 			tx.Begin()
-			zipData, err = sqlq.LoadLargeObject(tx, uint32(q.UInt64("diff_oid")))
+			pkgData, err = sqlq.LoadLargeObject(tx, uint32(q.UInt64("diff_oid")))
 			if err != nil {
 				return nil, nil, false, nerr.New(err)
 			}
 			tx.Rollback()
 
-			return res, zipData, false, nil
+			return res, pkgData, false, nil
 		} else {
 			// в кэше что-то старое и непонятное
 			updateCache = true
@@ -358,8 +430,13 @@ func (c *Cache) askCache(v processVersion, ctx context.Context,
 	return nil, nil, updateCache, nil
 }
 
-// создание архива
-func (c *Cache) createZip(fs []entity.FileInfo, ctx context.Context) ([]byte, error) {
+// отпечаток текущих настроек сжатия канала
+func (c *Cache) compressionKey(channel string) string {
+	return c.r.liveConfig().ChannelCompression(channel).Key()
+}
+
+// создание архива в формате format. В manifest заполняются только версии, файлы добавляются при упаковке
+func (c *Cache) createPackage(format string, manifest entity.Manifest, fs []entity.FileInfo, ctx context.Context) ([]byte, error) {
 	tx := sqlq.NewTx(c.r.Pool, ctx) // для загрузки LO
 	tx.Begin()
 	defer tx.Rollback()
@@ -369,17 +446,37 @@ func (c *Cache) createZip(fs []entity.FileInfo, ctx context.Context) ([]byte, er
 		return nil, nerr.New(err)
 	}
 	defer os.Remove(file.Name())
+	defer file.Close()
 
-	zipWriter := zip.NewWriter(file)
+	pw, err := newPackageWriter(format, c.r.liveConfig().ChannelCompression(manifest.To.Channel), file)
+	if err != nil {
+		return nil, nerr.New(err)
+	}
 
-	for _, fi := range fs {
-		if fi.Status == entity.FileRemoved {
-			continue
+	manifest.Format = entity.ManifestFormat
+	manifest.Files = make([]entity.ManifestFile, 0, len(fs))
+
+	for i, fi := range fs {
+		mf := entity.ManifestFile{
+			Name:       fi.Name,
+			Status:     fi.Status,
+			Sha256:     fi.Checksum,
+			Mode:       fi.Mode,
+			LinkTarget: fi.LinkTarget,
+		}
+		if !fi.ModTime.IsZero() {
+			mf.ModTime = fi.ModTime.Unix()
+		}
+		if manifest.Full {
+			mf.Status = entity.FileCreated
 		}
 
-		zipFile, err := zipWriter.Create(fi.Name)
-		if err != nil {
-			return nil, nerr.New(err)
+		if fi.Status == entity.FileRemoved {
+			mf.Mode = 0
+			mf.ModTime = 0
+			mf.LinkTarget = ""
+			manifest.Files = append(manifest.Files, mf)
+			continue
 		}
 
 		// грузим содержимое файла
@@ -388,20 +485,29 @@ func (c *Cache) createZip(fs []entity.FileInfo, ctx context.Context) ([]byte, er
 			return nil, nerr.New(err)
 		}
 
-		if _, err = zipFile.Write(data); err != nil {
+		if err = pw.addFile(&fs[i], data); err != nil {
 			return nil, nerr.New(err)
 		}
+
+		mf.Size = int64(len(data))
+		manifest.Files = append(manifest.Files, mf)
 	}
 
-	zipFile, err := zipWriter.Create(".update_file_info.txt")
+	// описание архива для клиентов
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
 		return nil, nerr.New(err)
 	}
-	if _, err = zipFile.Write(createDiffInfoFile(fs)); err != nil {
+	if err = pw.addMeta(entity.ManifestFileName, manifestData); err != nil {
+		return nil, nerr.New(err)
+	}
+
+	// текстовое описание для старых клиентов
+	if err = pw.addMeta(entity.DiffInfoFileName, createDiffInfoFile(fs)); err != nil {
 		return nil, nerr.New(err)
 	}
 
-	if err := zipWriter.Close(); err != nil {
+	if err := pw.Close(); err != nil {
 		return nil, nerr.New(err)
 	}
 
@@ -427,7 +533,7 @@ func createDiff(from []entity.FileInfo, to []entity.FileInfo) []entity.FileInfo
 		if fiFrom == nil { // новый файл
 			fiTo.Status = entity.FileCreated
 			res = append(res, *fiTo)
-		} else if fiFrom.Checksum != fiTo.Checksum { // измененный файл
+		} else if !fiFrom.SameContent(fiTo) { // измененный файл
 			fiTo.Status = entity.FileModified
 			res = append(res, *fiTo)
 		}
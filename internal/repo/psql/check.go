@@ -13,14 +13,25 @@ func (p *Repo) Check(сhannel string, version entity.Version, ctx context.Contex
 	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbWriteTimeout))
 	defer cancel()
 
+	start := time.Now()
 	ok, info, err := p.getUpdateInfo(сhannel, version, true, ctxChild)
 
 	if err == nil {
+		event := entity.Event{
+			Event:   entity.EventCheck,
+			Channel: сhannel,
+			From:    version,
+			To:      version,
+		}
 		if ok {
 			p.logOp(ctx, lg.Info, "update found: %s, %s => %s", сhannel, version.String(), info.Version.String())
+			event.To = info.Version
 		} else {
 			p.logOp(ctx, lg.Info, "update not found: %s, %s", сhannel, version.String())
 		}
+		event.Duration = time.Since(start)
+		p.addEvent(ctxChild, event)
+		p.touchClient(ctxChild, сhannel, version)
 	}
 
 	return ok, info, err
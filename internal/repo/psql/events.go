@@ -0,0 +1,243 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/sqlb"
+	"github.com/n-r-w/sqlq"
+	"github.com/n-r-w/tools"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// запись событий выдачи обновлений и состояния клиентов: записи копятся в очереди и записываются пачками в фоне,
+// чтобы не выполнять INSERT на каждый запрос /check и /update
+const (
+	eventsQueueSize     = 10000       // размер очереди. При переполнении новые записи отбрасываются
+	eventsBatchSize     = 500         // максимальное количество записей в одной пачке
+	eventsFlushInterval = time.Second // период записи неполной пачки
+)
+
+// eventRecord элемент очереди: событие или состояние клиента
+type eventRecord struct {
+	event  entity.Event
+	client entity.ClientInfo
+	state  *clientState // не nil - запись состояния клиента, а не события
+}
+
+// clientState последнее известное состояние клиента
+type clientState struct {
+	channel string
+	version entity.Version
+	client  entity.ClientInfo
+}
+
+// eventBatch пачка записей. Состояния клиентов объединяются по идентификатору: остается последнее
+type eventBatch struct {
+	events  []eventRecord
+	clients map[string]clientState
+}
+
+func (b *eventBatch) add(rec eventRecord) {
+	if rec.state == nil {
+		b.events = append(b.events, rec)
+		return
+	}
+	if b.clients == nil {
+		b.clients = map[string]clientState{}
+	}
+	b.clients[rec.state.client.ID] = *rec.state
+}
+
+func (b *eventBatch) size() int {
+	return len(b.events) + len(b.clients)
+}
+
+// addEvent сохранить событие выдачи обновления. Событие ставится в очередь, ошибки только логируются,
+// т.к. не должны мешать выдаче обновлений
+func (p *Repo) addEvent(ctx context.Context, e entity.Event) {
+	rec := eventRecord{event: e}
+	if ci := entity.GetClientInfoFromContext(ctx); ci != nil {
+		rec.client = *ci
+	}
+
+	select {
+	case p.events <- rec:
+	default:
+		p.logger.Warn("event queue is full, event dropped: %s, %s", e.Event, e.Channel)
+	}
+}
+
+// writeEvents фоновая запись очереди. Завершается по закрытию eventsStop после записи очереди
+func (p *Repo) writeEvents() {
+	defer close(p.eventsDone)
+
+	ticker := time.NewTicker(eventsFlushInterval)
+	defer ticker.Stop()
+
+	var batch eventBatch
+	for {
+		select {
+		case rec := <-p.events:
+			if batch.add(rec); batch.size() >= eventsBatchSize {
+				p.flushEvents(&batch)
+			}
+		case <-ticker.C:
+			p.flushEvents(&batch)
+		case <-p.eventsStop:
+			for {
+				select {
+				case rec := <-p.events:
+					if batch.add(rec); batch.size() >= eventsBatchSize {
+						p.flushEvents(&batch)
+					}
+				default:
+					p.flushEvents(&batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flushEvents запись пачки и ее очистка
+func (p *Repo) flushEvents(batch *eventBatch) {
+	if len(batch.events) > 0 {
+		p.insertEvents(batch.events)
+	}
+	if len(batch.clients) > 0 {
+		p.upsertClients(batch.clients)
+	}
+	*batch = eventBatch{}
+}
+
+// CloseEvents запись накопленных событий при остановке сервера
+func (p *Repo) CloseEvents() {
+	close(p.eventsStop)
+	<-p.eventsDone
+}
+
+// insertEvents запись пачки событий одним запросом
+func (p *Repo) insertEvents(batch []eventRecord) {
+	var values []string
+	for _, rec := range batch {
+		e, ci := rec.event, rec.client
+		value, err := sqlb.Bind(
+			`(:event, :channel, :from_major, :from_minor, :from_patch, :from_revision, :to_major, :to_minor, :to_patch, :to_revision,
+			:bytes, :duration, :ip, :real_ip, :local_ip, :app_login, :os_login)`,
+			map[string]interface{}{
+				"event":         e.Event,
+				"channel":       e.Channel,
+				"from_major":    e.From.Major,
+				"from_minor":    e.From.Minor,
+				"from_patch":    e.From.Patch,
+				"from_revision": e.From.Revision,
+				"to_major":      e.To.Major,
+				"to_minor":      e.To.Minor,
+				"to_patch":      e.To.Patch,
+				"to_revision":   e.To.Revision,
+				"bytes":         e.Bytes,
+				"duration":      int(e.Duration.Milliseconds()),
+				"ip":            ci.IP,
+				"real_ip":       ci.RealIP,
+				"local_ip":      ci.LocalIP,
+				"app_login":     ci.AppLogin,
+				"os_login":      ci.OsLogin,
+			}, "addEvent")
+		if err != nil {
+			p.logger.Error("add event: %v", err)
+			continue
+		}
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	sql := fmt.Sprintf(`INSERT INTO public.events(
+			event, channel, from_major, from_minor, from_patch, from_revision, to_major, to_minor, to_patch, to_revision,
+			bytes, duration, ip, real_ip, local_ip, app_login, os_login)
+			VALUES %s`, strings.Join(values, ","))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(p.config.DbWriteTimeout))
+	defer cancel()
+
+	tx := sqlq.NewTx(p.Pool, ctx)
+	if err := tx.Begin(); err != nil {
+		p.logger.Error("add events: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := sqlq.ExecTx(tx, sql); err != nil {
+		p.logger.Error("add events: %v", nerr.New(err, tools.SimplifyString(sql)))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		p.logger.Error("add events: %v", err)
+	}
+}
+
+// Stats статистика выдачи обновлений по каналам, версиям и дням
+func (p *Repo) Stats(filter entity.StatFilter, ctx context.Context) ([]entity.Stat, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbReadTimeout))
+	defer cancel()
+
+	sql, err := sqlb.Bind(
+		`SELECT channel, to_major, to_minor, to_patch, to_revision, to_char(record_time, 'YYYY-MM-DD') AS day,
+			SUM(CASE WHEN event = 'check' THEN 1 ELSE 0 END) AS checks,
+			SUM(CASE WHEN event = 'cache' THEN 1 ELSE 0 END) AS from_cache,
+			SUM(CASE WHEN event = 'diff' THEN 1 ELSE 0 END) AS diffs,
+			SUM(CASE WHEN event = 'full' THEN 1 ELSE 0 END) AS full_count,
+			SUM(bytes) AS bytes,
+			COALESCE(AVG(duration) FILTER (WHERE event <> 'check'), 0)::integer AS avg_duration
+		FROM events
+		WHERE record_time >= :from AND record_time < :to AND (:channel = '' OR channel = :channel)
+		GROUP BY channel, to_major, to_minor, to_patch, to_revision, day
+		ORDER BY day DESC, channel, to_major DESC, to_minor DESC, to_patch DESC, to_revision DESC`,
+		map[string]interface{}{
+			"channel": filter.Channel,
+			"from":    filter.From,
+			"to":      filter.To,
+		}, "Stats")
+	if err != nil {
+		return nil, err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	q, err := sqlq.SelectTx(tx, sql)
+	if err != nil {
+		return nil, nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	res := []entity.Stat{}
+	for q.Next() {
+		res = append(res, entity.Stat{
+			Channel: q.String("channel"),
+			Version: entity.Version{
+				Major:    q.Int("to_major"),
+				Minor:    q.Int("to_minor"),
+				Patch:    q.Int("to_patch"),
+				Revision: q.Int("to_revision"),
+			},
+			Day:         q.String("day"),
+			Checks:      q.Int("checks"),
+			FromCache:   q.Int("from_cache"),
+			Diffs:       q.Int("diffs"),
+			Full:        q.Int("full_count"),
+			Bytes:       q.UInt64("bytes"),
+			AvgDuration: q.Int("avg_duration"),
+		})
+	}
+
+	return res, nil
+}
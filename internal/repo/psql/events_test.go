@@ -0,0 +1,23 @@
+package psql
+
+import (
+	"testing"
+
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+func TestEventBatchCoalescesClients(t *testing.T) {
+	var b eventBatch
+	b.add(eventRecord{event: entity.Event{Event: entity.EventCheck}})
+	b.add(eventRecord{event: entity.Event{Event: entity.EventCheck}})
+	b.add(eventRecord{state: &clientState{version: entity.Version{Major: 1}, client: entity.ClientInfo{ID: "a"}}})
+	b.add(eventRecord{state: &clientState{version: entity.Version{Major: 2}, client: entity.ClientInfo{ID: "a"}}})
+	b.add(eventRecord{state: &clientState{version: entity.Version{Major: 1}, client: entity.ClientInfo{ID: "b"}}})
+
+	if b.size() != 4 || len(b.events) != 2 || len(b.clients) != 2 {
+		t.Fatalf("size = %d, events = %d, clients = %d", b.size(), len(b.events), len(b.clients))
+	}
+	if v := b.clients["a"].version.Major; v != 2 {
+		t.Fatalf("client a version = %d, want last state 2", v)
+	}
+}
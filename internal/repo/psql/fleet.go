@@ -0,0 +1,223 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/sqlb"
+	"github.com/n-r-w/sqlq"
+	"github.com/n-r-w/tools"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// максимальное количество клиентов в ответе по умолчанию
+const defaultFleetLimit = 1000
+
+// условие отбора клиентов по фильтру
+const fleetWhere = `(:channel = '' OR channel = :channel)
+	AND (:parts < 1 OR major = :major) AND (:parts < 2 OR minor = :minor)
+	AND (:parts < 3 OR patch = :patch) AND (:parts < 4 OR revision = :revision)
+	AND (:active_days = 0 OR last_seen >= now() - make_interval(days => :active_days))
+	AND (:inactive_days = 0 OR last_seen < now() - make_interval(days => :inactive_days))`
+
+// touchClient обновить последнее известное состояние клиента. Состояние ставится в очередь событий
+// и записывается в фоне, ошибки только логируются
+func (p *Repo) touchClient(ctx context.Context, сhannel string, version entity.Version) {
+	ci := entity.GetClientInfoFromContext(ctx)
+	if ci == nil || len(ci.ID) == 0 {
+		return
+	}
+
+	select {
+	case p.events <- eventRecord{state: &clientState{channel: сhannel, version: version, client: *ci}}:
+	default:
+		p.logger.Warn("event queue is full, client state dropped: %s", ci.ID)
+	}
+}
+
+// upsertClients запись состояния клиентов одним запросом
+func (p *Repo) upsertClients(clients map[string]clientState) {
+	// одинаковый порядок строк в разных серверах исключает взаимные блокировки
+	ids := make([]string, 0, len(clients))
+	for id := range clients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var values []string
+	for _, id := range ids {
+		c := clients[id]
+		value, err := sqlb.Bind(
+			`(:id, :channel, :major, :minor, :patch, :revision, :ip, :real_ip, :local_ip, :app_login, :os_login)`,
+			map[string]interface{}{
+				"id":        id,
+				"channel":   c.channel,
+				"major":     c.version.Major,
+				"minor":     c.version.Minor,
+				"patch":     c.version.Patch,
+				"revision":  c.version.Revision,
+				"ip":        c.client.IP,
+				"real_ip":   c.client.RealIP,
+				"local_ip":  c.client.LocalIP,
+				"app_login": c.client.AppLogin,
+				"os_login":  c.client.OsLogin,
+			}, "touchClient")
+		if err != nil {
+			p.logger.Error("touch client: %v", err)
+			continue
+		}
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	sql := fmt.Sprintf(
+		`INSERT INTO public.clients(id, channel, major, minor, patch, revision, ip, real_ip, local_ip, app_login, os_login)
+			VALUES %s
+		ON CONFLICT (id) DO UPDATE SET
+			last_seen = now(), channel = EXCLUDED.channel,
+			major = EXCLUDED.major, minor = EXCLUDED.minor, patch = EXCLUDED.patch, revision = EXCLUDED.revision,
+			ip = EXCLUDED.ip, real_ip = EXCLUDED.real_ip, local_ip = EXCLUDED.local_ip,
+			app_login = EXCLUDED.app_login, os_login = EXCLUDED.os_login`, strings.Join(values, ","))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(p.config.DbWriteTimeout))
+	defer cancel()
+
+	tx := sqlq.NewTx(p.Pool, ctx)
+	if err := tx.Begin(); err != nil {
+		p.logger.Error("touch clients: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := sqlq.ExecTx(tx, sql); err != nil {
+		p.logger.Error("touch clients: %v", nerr.New(err, tools.SimplifyString(sql)))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		p.logger.Error("touch clients: %v", err)
+	}
+}
+
+// Fleet список клиентов по фильтру
+func (p *Repo) Fleet(filter entity.FleetFilter, ctx context.Context) (entity.FleetList, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbReadTimeout))
+	defer cancel()
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultFleetLimit
+	}
+
+	args := fleetArgs(filter)
+	args["limit"] = filter.Limit
+
+	sql, err := sqlb.Bind(fmt.Sprintf(
+		`SELECT id, first_seen, last_seen, channel, major, minor, patch, revision,
+			COALESCE(ip, '') AS ip, COALESCE(real_ip, '') AS real_ip, COALESCE(local_ip, '') AS local_ip,
+			COALESCE(app_login, '') AS app_login, COALESCE(os_login, '') AS os_login,
+			COUNT(*) OVER() AS total
+		FROM clients
+		WHERE %s
+		ORDER BY last_seen DESC
+		LIMIT :limit`, fleetWhere), args, "Fleet")
+	if err != nil {
+		return entity.FleetList{}, err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return entity.FleetList{}, err
+	}
+	defer tx.Rollback()
+
+	q, err := sqlq.SelectTx(tx, sql)
+	if err != nil {
+		return entity.FleetList{}, nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	res := entity.FleetList{Clients: []entity.FleetClient{}}
+	for q.Next() {
+		res.Total = q.Int("total")
+		res.Clients = append(res.Clients, entity.FleetClient{
+			ID:        q.String("id"),
+			FirstSeen: q.Time("first_seen"),
+			LastSeen:  q.Time("last_seen"),
+			Channel:   q.String("channel"),
+			Version: entity.Version{
+				Major:    q.Int("major"),
+				Minor:    q.Int("minor"),
+				Patch:    q.Int("patch"),
+				Revision: q.Int("revision"),
+			},
+			IP:       q.String("ip"),
+			RealIP:   q.String("real_ip"),
+			LocalIP:  q.String("local_ip"),
+			AppLogin: q.String("app_login"),
+			OsLogin:  q.String("os_login"),
+		})
+	}
+
+	return res, nil
+}
+
+// FleetVersions количество клиентов по каналам и версиям
+func (p *Repo) FleetVersions(filter entity.FleetFilter, ctx context.Context) ([]entity.FleetVersion, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbReadTimeout))
+	defer cancel()
+
+	sql, err := sqlb.Bind(fmt.Sprintf(
+		`SELECT channel, major, minor, patch, revision, COUNT(*) AS clients
+		FROM clients
+		WHERE %s
+		GROUP BY channel, major, minor, patch, revision
+		ORDER BY channel, major DESC, minor DESC, patch DESC, revision DESC`, fleetWhere), fleetArgs(filter), "FleetVersions")
+	if err != nil {
+		return nil, err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	q, err := sqlq.SelectTx(tx, sql)
+	if err != nil {
+		return nil, nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	res := []entity.FleetVersion{}
+	for q.Next() {
+		res = append(res, entity.FleetVersion{
+			Channel: q.String("channel"),
+			Version: entity.Version{
+				Major:    q.Int("major"),
+				Minor:    q.Int("minor"),
+				Patch:    q.Int("patch"),
+				Revision: q.Int("revision"),
+			},
+			Clients: q.Int("clients"),
+		})
+	}
+
+	return res, nil
+}
+
+func fleetArgs(filter entity.FleetFilter) map[string]interface{} {
+	return map[string]interface{}{
+		"channel":       filter.Channel,
+		"parts":         filter.VersionParts,
+		"major":         filter.Version.Major,
+		"minor":         filter.Version.Minor,
+		"patch":         filter.Version.Patch,
+		"revision":      filter.Version.Revision,
+		"active_days":   filter.ActiveDays,
+		"inactive_days": filter.InactiveDays,
+	}
+}
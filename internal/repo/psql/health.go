@@ -0,0 +1,97 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/sqlq"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// Ready проверка готовности: соединение с БД, расширение lo, версия схемы БД, временный каталог для архивов
+func (p *Repo) Ready(ctx context.Context) entity.Readiness {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbReadTimeout))
+	defer cancel()
+
+	res := entity.Readiness{Ready: true}
+	add := func(name string, err error) {
+		check := entity.HealthCheck{Name: name, OK: err == nil}
+		if err != nil {
+			check.Error = err.Error()
+			res.Ready = false
+		}
+		res.Checks = append(res.Checks, check)
+	}
+
+	dbErr := p.checkDB(ctxChild)
+	add("database", dbErr)
+	if dbErr == nil {
+		add("lo_extension", p.checkLoExtension(ctxChild))
+		add("schema", p.checkSchema(ctxChild))
+	}
+	add("temp_dir", checkTempDir())
+
+	return res
+}
+
+func (p *Repo) checkDB(ctx context.Context) error {
+	q, err := sqlq.SelectRow(p.Pool, ctx, `SELECT 1 AS ok`)
+	if err != nil {
+		return nerr.New(err)
+	}
+	if q == nil {
+		return nerr.New("no response")
+	}
+	return nil
+}
+
+func (p *Repo) checkLoExtension(ctx context.Context) error {
+	q, err := sqlq.SelectRow(p.Pool, ctx, `SELECT extname FROM pg_extension WHERE extname = 'lo'`)
+	if err != nil {
+		return nerr.New(err)
+	}
+	if q == nil {
+		return nerr.New("extension lo not installed")
+	}
+	return nil
+}
+
+func (p *Repo) checkSchema(ctx context.Context) error {
+	status, err := p.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, m := range status {
+		if !m.Applied {
+			pending = append(pending, m.Version)
+		}
+	}
+	if len(pending) > 0 {
+		return nerr.New(fmt.Sprintf("pending migrations: %s", strings.Join(pending, ", ")))
+	}
+
+	return nil
+}
+
+// во временном каталоге создаются архивы обновлений
+func checkTempDir() error {
+	file, err := ioutil.TempFile("", "upsrvcheck")
+	if err != nil {
+		return nerr.New(err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err = file.Write([]byte("ok")); err != nil {
+		file.Close()
+		return nerr.New(err)
+	}
+
+	return file.Close()
+}
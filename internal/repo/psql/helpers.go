@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/n-r-w/lg"
 	"github.com/n-r-w/nerr"
@@ -89,30 +90,50 @@ func (p *Repo) getUpdateInfo(сhannel string, version entity.Version, lastUpdate
 	}
 
 	// файлы
-	sql, err = sqlb.BindOne(
-		`SELECT file_name, checksum, data_oid
-		FROM files		
-		WHERE id_update = :id_update`,
-		"id_update", q.UInt64("id"),
-		"getUpdateInfoFiles")
+	sql, err = sqlb.BindOne(filesQuery, "id_update", q.UInt64("id"), "getUpdateInfoFiles")
 	if err != nil {
 		return false, entity.UpdateInfo{}, err
 	}
 	if q, err = sqlq.SelectTx(tx, sql); err != nil {
 		return false, entity.UpdateInfo{}, nerr.New(err, sql)
 	}
+	info.Files = readFiles(q)
 
+	return true, info, nil
+}
+
+// информация о файлах версии без содержимого
+const filesQuery = `SELECT file_name, checksum, data_oid, mode, link_target, size,
+		COALESCE(EXTRACT(EPOCH FROM mod_time), 0)::bigint AS mod_unix
+	FROM files		
+	WHERE id_update = :id_update`
+
+// результат запроса из нескольких строк
+type queryRows interface {
+	queryRow
+	Next() bool
+}
+
+// readFiles чтение результата filesQuery
+func readFiles(q queryRows) []entity.FileInfo {
+	var res []entity.FileInfo
 	for q.Next() {
 		fi := entity.FileInfo{
 			Name:     q.String("file_name"),
 			Checksum: q.String("checksum"),
 			DataID:   uint32(q.UInt64("data_oid")),
+			Size:     int64(q.UInt64("size")),
+
+			Mode:       uint32(q.Int("mode")),
+			LinkTarget: q.String("link_target"),
+		}
+		if modUnix := q.Int("mod_unix"); modUnix > 0 {
+			fi.ModTime = time.Unix(int64(modUnix), 0)
 		}
 
-		info.Files = append(info.Files, fi)
+		res = append(res, fi)
 	}
-
-	return true, info, nil
+	return res
 }
 
 func (p *Repo) logOp(ctx context.Context, level lg.Level, format string, args ...any) {
@@ -0,0 +1,265 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/sqlb"
+	"github.com/n-r-w/sqlq"
+	"github.com/n-r-w/tools"
+	"github.com/n-r-w/updsrv/internal/entity"
+	"github.com/n-r-w/updsrv/migration"
+)
+
+// ключ pg_advisory_xact_lock, чтобы несколько серверов не меняли схему БД одновременно
+const migrateLockKey = 7395521039
+
+// MigrateUp применение всех непримененных миграций. Возвращает примененные версии
+func (p *Repo) MigrateUp(ctx context.Context) ([]string, error) {
+	list, err := migration.List()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.migrate(ctx, func(applied map[string]time.Time, mtx *migrateTx) ([]string, error) {
+		var res []string
+		for _, m := range list {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if err := mtx.exec(m.Up); err != nil {
+				return nil, nerr.New(err, m.Version)
+			}
+			if err := mtx.mark(m.Version, true); err != nil {
+				return nil, err
+			}
+			res = append(res, m.Version)
+		}
+		return res, nil
+	})
+}
+
+// MigrateDown откат последних count примененных миграций. Возвращает отмененные версии
+func (p *Repo) MigrateDown(count int, ctx context.Context) ([]string, error) {
+	list, err := migration.List()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.migrate(ctx, func(applied map[string]time.Time, mtx *migrateTx) ([]string, error) {
+		if err := checkUnknownMigrations(list, applied); err != nil {
+			return nil, err
+		}
+
+		var res []string
+		for i := len(list) - 1; i >= 0 && len(res) < count; i-- {
+			m := list[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if err := mtx.exec(m.Down); err != nil {
+				return nil, nerr.New(err, m.Version)
+			}
+			if err := mtx.mark(m.Version, false); err != nil {
+				return nil, err
+			}
+			res = append(res, m.Version)
+		}
+		return res, nil
+	})
+}
+
+// MigrateBaseline отметить миграции до version включительно как примененные без их выполнения.
+// Для БД, схема которой была создана вручную скриптами из migration/up
+func (p *Repo) MigrateBaseline(version string, ctx context.Context) ([]string, error) {
+	list, err := migration.List()
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	for _, m := range list {
+		if m.Version == version {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, nerr.NewFmt("unknown migration version: %s", version)
+	}
+
+	return p.migrate(ctx, func(applied map[string]time.Time, mtx *migrateTx) ([]string, error) {
+		var res []string
+		for _, m := range list {
+			if _, ok := applied[m.Version]; !ok {
+				if err := mtx.mark(m.Version, true); err != nil {
+					return nil, err
+				}
+				res = append(res, m.Version)
+			}
+			if m.Version == version {
+				break
+			}
+		}
+		return res, nil
+	})
+}
+
+// Migrations состояние миграций: все известные версии и примененные версии, о которых сервер не знает
+func (p *Repo) Migrations(ctx context.Context) ([]entity.MigrationStatus, error) {
+	list, err := migration.List()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := p.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]bool{}
+	res := make([]entity.MigrationStatus, 0, len(list))
+	for _, m := range list {
+		known[m.Version] = true
+		t, ok := applied[m.Version]
+		res = append(res, entity.MigrationStatus{
+			Version:   m.Version,
+			Applied:   ok,
+			ApplyTime: t,
+		})
+	}
+	for v, t := range applied {
+		if !known[v] {
+			res = append(res, entity.MigrationStatus{
+				Version:   v,
+				Applied:   true,
+				ApplyTime: t,
+			})
+		}
+	}
+
+	return res, nil
+}
+
+// CheckSchemaVersion ошибка, если схема БД старее, чем ожидает сервер
+func (p *Repo) CheckSchemaVersion(ctx context.Context) error {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbReadTimeout))
+	defer cancel()
+
+	if err := p.checkSchema(ctxChild); err != nil {
+		return nerr.New(err, "database schema is out of date, run 'updsrv migrate up'")
+	}
+	return nil
+}
+
+// migrate выполнение action в одной транзакции под блокировкой. Схема БД меняется целиком или не меняется вовсе.
+// Таймаут не ограничивается: миграции на больших таблицах могут выполняться долго
+func (p *Repo) migrate(ctx context.Context,
+	action func(applied map[string]time.Time, mtx *migrateTx) ([]string, error)) ([]string, error) {
+	tx := sqlq.NewTx(p.Pool, ctx)
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	mtx := &migrateTx{exec: func(sql string) error {
+		if _, err := sqlq.ExecTx(tx, sql); err != nil {
+			return nerr.New(err, tools.SimplifyString(sql))
+		}
+		return nil
+	}}
+
+	// второй сервер ждет, пока первый не закончит
+	if err := mtx.exec(fmt.Sprintf(`SELECT pg_advisory_xact_lock(%d)`, migrateLockKey)); err != nil {
+		return nil, err
+	}
+
+	if err := mtx.exec(`CREATE TABLE IF NOT EXISTS public.schema_migrations
+		(
+			version text NOT NULL,
+			apply_time timestamp with time zone NOT NULL DEFAULT Now(),
+
+			PRIMARY KEY (version)
+		)`); err != nil {
+		return nil, err
+	}
+
+	q, err := sqlq.SelectTx(tx, `SELECT version, apply_time FROM public.schema_migrations`)
+	if err != nil {
+		return nil, nerr.New(err)
+	}
+	applied := map[string]time.Time{}
+	for q.Next() {
+		applied[q.String("version")] = q.Time("apply_time")
+	}
+
+	res, err := action(applied, mtx)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, tx.Commit()
+}
+
+// примененные миграции. Пустой список, если миграции еще не выполнялись
+func (p *Repo) appliedMigrations(ctx context.Context) (map[string]time.Time, error) {
+	tx := sqlq.NewTx(p.Pool, ctx)
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res := map[string]time.Time{}
+
+	q, err := sqlq.SelectTxRow(tx, `SELECT COALESCE(to_regclass('public.schema_migrations')::text, '') AS name`)
+	if err != nil {
+		return nil, nerr.New(err)
+	}
+	if q == nil || len(q.String("name")) == 0 {
+		return res, nil
+	}
+
+	if q, err = sqlq.SelectTx(tx, `SELECT version, apply_time FROM public.schema_migrations`); err != nil {
+		return nil, nerr.New(err)
+	}
+	for q.Next() {
+		res[q.String("version")] = q.Time("apply_time")
+	}
+
+	return res, nil
+}
+
+// откат невозможен, если в БД есть миграции от более новой версии сервера
+func checkUnknownMigrations(list []migration.Migration, applied map[string]time.Time) error {
+	known := map[string]bool{}
+	for _, m := range list {
+		known[m.Version] = true
+	}
+	for v := range applied {
+		if !known[v] {
+			return nerr.NewFmt("database has migration %s unknown to this version of server", v)
+		}
+	}
+	return nil
+}
+
+// migrateTx транзакция, в которой выполняются миграции
+type migrateTx struct {
+	exec func(sql string) error
+}
+
+// mark отметка о применении или откате миграции
+func (t *migrateTx) mark(version string, applied bool) error {
+	query := `DELETE FROM public.schema_migrations WHERE version = :version`
+	if applied {
+		query = `INSERT INTO public.schema_migrations(version) VALUES (:version)`
+	}
+
+	sql, err := sqlb.BindOne(query, "version", version, "Migration")
+	if err != nil {
+		return err
+	}
+	return t.exec(sql)
+}
@@ -0,0 +1,157 @@
+package psql
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/n-r-w/updsrv/internal/config"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// packageWriter упаковка архива обновления в формате, запрошенном клиентом
+type packageWriter interface {
+	// addFile добавить файл обновления. Для символической ссылки data - путь, на который она указывает
+	addFile(fi *entity.FileInfo, data []byte) error
+	// addMeta добавить служебный файл (манифест и т.п.)
+	addMeta(name string, data []byte) error
+	Close() error
+}
+
+func newPackageWriter(format string, cmp config.Compression, w io.Writer) (packageWriter, error) {
+	if format == entity.PackageTarZstd {
+		return newTarZstdPackage(cmp, w)
+	}
+	return newZipPackage(cmp, w), nil
+}
+
+// zipPackage архив zip. Уже сжатые файлы сохраняются без повторного сжатия
+type zipPackage struct {
+	zw    *zip.Writer
+	store map[string]bool
+}
+
+func newZipPackage(cmp config.Compression, w io.Writer) *zipPackage {
+	p := &zipPackage{
+		zw:    zip.NewWriter(w),
+		store: map[string]bool{},
+	}
+
+	if cmp.ZipLevel > 0 {
+		level := cmp.ZipLevel
+		p.zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+	for _, ext := range cmp.Store {
+		p.store[strings.ToLower(ext)] = true
+	}
+
+	return p
+}
+
+func (p *zipPackage) addFile(fi *entity.FileInfo, data []byte) error {
+	// права доступа, время изменения и символические ссылки как в исходном архиве
+	header := &zip.FileHeader{Name: fi.Name, Method: zip.Deflate, Modified: fi.ModTime}
+	if p.store[strings.ToLower(path.Ext(fi.Name))] {
+		header.Method = zip.Store
+	}
+	if len(fi.LinkTarget) > 0 {
+		header.SetMode(os.FileMode(fi.Mode).Perm() | os.ModeSymlink)
+	} else {
+		header.SetMode(os.FileMode(fi.Mode).Perm())
+	}
+
+	w, err := p.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (p *zipPackage) addMeta(name string, data []byte) error {
+	w, err := p.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (p *zipPackage) Close() error {
+	return p.zw.Close()
+}
+
+// tarZstdPackage архив tar, сжатый zstd. Сжимается поток целиком, поэтому настройка Store не применяется
+type tarZstdPackage struct {
+	enc *zstd.Encoder
+	tw  *tar.Writer
+}
+
+func newTarZstdPackage(cmp config.Compression, w io.Writer) (*tarZstdPackage, error) {
+	opts := []zstd.EOption{}
+	if cmp.ZstdLevel > 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(cmp.ZstdLevel)))
+	}
+
+	enc, err := zstd.NewWriter(w, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tarZstdPackage{
+		enc: enc,
+		tw:  tar.NewWriter(enc),
+	}, nil
+}
+
+func (p *tarZstdPackage) addFile(fi *entity.FileInfo, data []byte) error {
+	header := &tar.Header{
+		Name:    fi.Name,
+		Mode:    int64(os.FileMode(fi.Mode).Perm()),
+		ModTime: fi.ModTime,
+	}
+	if len(fi.LinkTarget) > 0 {
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = fi.LinkTarget
+		return p.tw.WriteHeader(header)
+	}
+
+	header.Typeflag = tar.TypeReg
+	header.Size = int64(len(data))
+	if err := p.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := p.tw.Write(data)
+	return err
+}
+
+func (p *tarZstdPackage) addMeta(name string, data []byte) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     entity.DefaultFileMode,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+	}
+	if err := p.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := p.tw.Write(data)
+	return err
+}
+
+func (p *tarZstdPackage) Close() error {
+	if err := p.tw.Close(); err != nil {
+		p.enc.Close()
+		return err
+	}
+	return p.enc.Close()
+}
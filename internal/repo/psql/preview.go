@@ -0,0 +1,108 @@
+package psql
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/sqlb"
+	"github.com/n-r-w/sqlq"
+	"github.com/n-r-w/tools"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// Preview что изменится при публикации версии: конфликт с существующей версией и разница с последней включенной.
+// В БД ничего не записывается
+func (p *Repo) Preview(ui *entity.UpdateInfo, ctx context.Context) (entity.PublishPreview, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbReadTimeout))
+	defer cancel()
+
+	res := entity.PublishPreview{
+		Channel:   ui.Channel,
+		Version:   ui.Version,
+		FileCount: len(ui.Files),
+	}
+	for _, fi := range ui.Files {
+		res.TotalSize += fi.Size
+	}
+
+	// версия с тем же номером, в том числе выключенная (uk_updates)
+	sql, err := sqlb.Bind(
+		`SELECT id, record_time, channel, major, minor, patch, revision, build_time, COALESCE(info, '') AS info,
+			CASE WHEN enabled THEN 1 ELSE 0 END AS enabled
+		FROM updates
+		WHERE channel = :channel AND major = :major AND minor = :minor AND patch = :patch AND revision = :revision`,
+		map[string]interface{}{
+			"channel":  ui.Channel,
+			"major":    ui.Version.Major,
+			"minor":    ui.Version.Minor,
+			"patch":    ui.Version.Patch,
+			"revision": ui.Version.Revision,
+		}, "PreviewConflict")
+	if err != nil {
+		return entity.PublishPreview{}, err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return entity.PublishPreview{}, err
+	}
+	defer tx.Rollback()
+
+	q, err := sqlq.SelectTxRow(tx, sql)
+	if err != nil {
+		return entity.PublishPreview{}, nerr.New(err, tools.SimplifyString(sql))
+	}
+	if q != nil {
+		res.Conflict = &entity.UpdateInfo{
+			ID:         q.UInt64("id"),
+			CreateTime: q.Time("record_time"),
+			BuildTime:  q.Time("build_time"),
+			Channel:    q.String("channel"),
+			Version:    ui.Version,
+			Info:       q.String("info"),
+			Enabled:    q.Int("enabled") == 1,
+		}
+	}
+
+	// последняя версия, которую получают клиенты
+	found, latest, err := p.getUpdateInfo(ui.Channel, entity.Version{}, true, ctxChild)
+	if err != nil {
+		return entity.PublishPreview{}, err
+	}
+
+	var from []entity.FileInfo
+	if found {
+		from = latest.Files
+		latest.Files = nil
+		res.Latest = &latest
+	}
+
+	to := make([]entity.FileInfo, len(ui.Files))
+	for i, fi := range ui.Files {
+		to[i] = fi
+		to[i].Data = nil
+	}
+
+	if res.Diff.Files = createDiff(from, to); res.Diff.Files == nil {
+		res.Diff.Files = []entity.FileInfo{}
+	}
+	sort.Slice(res.Diff.Files, func(i, j int) bool { return res.Diff.Files[i].Name < res.Diff.Files[j].Name })
+
+	for _, fi := range res.Diff.Files {
+		switch fi.Status {
+		case entity.FileCreated:
+			res.Diff.Added++
+			res.Diff.AddedSize += fi.Size
+		case entity.FileRemoved:
+			res.Diff.Removed++
+			res.Diff.RemovedSize += fi.Size
+		case entity.FileModified:
+			res.Diff.Modified++
+			res.Diff.ModifiedSize += fi.Size
+		}
+	}
+
+	return res, nil
+}
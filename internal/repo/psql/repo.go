@@ -2,24 +2,59 @@
 package psql
 
 import (
+	"sync"
+
 	"github.com/n-r-w/lg"
 	"github.com/n-r-w/postgres"
 	"github.com/n-r-w/updsrv/internal/config"
+	"github.com/n-r-w/updsrv/internal/metrics"
 )
 
 type Repo struct {
 	*postgres.Service
-	config *config.Config
-	cache  *Cache
-	logger lg.Logger
+	config  *config.Config
+	cache   *Cache
+	logger  lg.Logger
+	metrics *metrics.Service
+
+	mutex sync.RWMutex
+	live  *config.Config // параметры, которые меняются без перезапуска
+
+	events     chan eventRecord // очередь событий выдачи обновлений и состояния клиентов на запись
+	eventsStop chan struct{}
+	eventsDone chan struct{}
 }
 
-func NewRepo(pg *postgres.Service, config *config.Config, logger lg.Logger) *Repo {
+func NewRepo(pg *postgres.Service, config *config.Config, logger lg.Logger, metrics *metrics.Service) *Repo {
 	r := &Repo{
 		Service: pg,
 		config:  config,
 		logger:  logger,
+		metrics: metrics,
+		live:    config,
+
+		events:     make(chan eventRecord, eventsQueueSize),
+		eventsStop: make(chan struct{}),
+		eventsDone: make(chan struct{}),
 	}
 	r.cache = NewCache(r) // циклическая ссылка в go не приводит к утечке памяти
+
+	go r.writeEvents()
 	return r
 }
+
+// Reload применение новых значений параметров, которые меняются без перезапуска: ограничение нагрузки и хранение версий
+func (p *Repo) Reload(cfg *config.Config) {
+	p.mutex.Lock()
+	p.live = cfg
+	p.mutex.Unlock()
+
+	p.cache.setLimit(cfg.RateLimit, cfg.RateLimitBurst)
+}
+
+// актуальные параметры, которые меняются без перезапуска
+func (p *Repo) liveConfig() *config.Config {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.live
+}
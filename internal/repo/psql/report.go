@@ -0,0 +1,131 @@
+package psql
+
+import (
+	"context"
+	"time"
+
+	"github.com/n-r-w/lg"
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/sqlb"
+	"github.com/n-r-w/sqlq"
+	"github.com/n-r-w/tools"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// AddReport сохранить отчет клиента о результате установки обновления
+func (p *Repo) AddReport(report entity.InstallReport, ctx context.Context) error {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbWriteTimeout))
+	defer cancel()
+
+	ci := entity.GetClientInfoFromContext(ctx)
+
+	sql, err := sqlb.Bind(
+		`INSERT INTO public.reports(
+			channel, from_major, from_minor, from_patch, from_revision, to_major, to_minor, to_patch, to_revision,
+			success, error, duration, ip, real_ip, local_ip, app_login, os_login)
+			VALUES (:channel, :from_major, :from_minor, :from_patch, :from_revision, :to_major, :to_minor, :to_patch, :to_revision,
+			:success, :error, :duration, :ip, :real_ip, :local_ip, :app_login, :os_login)`,
+		map[string]interface{}{
+			"channel":       report.Channel,
+			"from_major":    report.From.Major,
+			"from_minor":    report.From.Minor,
+			"from_patch":    report.From.Patch,
+			"from_revision": report.From.Revision,
+			"to_major":      report.To.Major,
+			"to_minor":      report.To.Minor,
+			"to_patch":      report.To.Patch,
+			"to_revision":   report.To.Revision,
+			"success":       report.Success,
+			"error":         report.Error,
+			"duration":      report.Duration,
+			"ip":            ci.IP,
+			"real_ip":       ci.RealIP,
+			"local_ip":      ci.LocalIP,
+			"app_login":     ci.AppLogin,
+			"os_login":      ci.OsLogin,
+		}, "AddReport")
+	if err != nil {
+		return err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = sqlq.ExecTx(tx, sql); err != nil {
+		return nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if report.Success {
+		p.logOp(ctx, lg.Info, "update installed: %s, %s => %s", report.Channel, report.From.String(), report.To.String())
+	} else {
+		p.logOp(ctx, lg.Warn, "update install failed: %s, %s => %s: %s", report.Channel, report.From.String(), report.To.String(), report.Error)
+	}
+
+	return nil
+}
+
+// InstallStats статистика результатов установки по каналам и версиям
+func (p *Repo) InstallStats(filter entity.StatFilter, ctx context.Context) ([]entity.InstallStat, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbReadTimeout))
+	defer cancel()
+
+	sql, err := sqlb.Bind(
+		`SELECT channel, to_major, to_minor, to_patch, to_revision,
+			COUNT(*) AS total,
+			SUM(CASE WHEN success THEN 0 ELSE 1 END) AS failed,
+			COALESCE(AVG(duration) FILTER (WHERE success), 0)::integer AS avg_duration,
+			COALESCE((array_agg(error ORDER BY record_time DESC) FILTER (WHERE NOT success))[1], '') AS last_error
+		FROM reports
+		WHERE record_time >= :from AND record_time < :to AND (:channel = '' OR channel = :channel)
+		GROUP BY channel, to_major, to_minor, to_patch, to_revision
+		ORDER BY channel, to_major DESC, to_minor DESC, to_patch DESC, to_revision DESC`,
+		map[string]interface{}{
+			"channel": filter.Channel,
+			"from":    filter.From,
+			"to":      filter.To,
+		}, "InstallStats")
+	if err != nil {
+		return nil, err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	q, err := sqlq.SelectTx(tx, sql)
+	if err != nil {
+		return nil, nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	res := []entity.InstallStat{}
+	for q.Next() {
+		s := entity.InstallStat{
+			Channel: q.String("channel"),
+			Version: entity.Version{
+				Major:    q.Int("to_major"),
+				Minor:    q.Int("to_minor"),
+				Patch:    q.Int("to_patch"),
+				Revision: q.Int("to_revision"),
+			},
+			Total:       q.Int("total"),
+			Failed:      q.Int("failed"),
+			AvgDuration: q.Int("avg_duration"),
+			LastError:   q.String("last_error"),
+		}
+		if s.Total > 0 {
+			s.FailureRate = float64(s.Failed) / float64(s.Total)
+		}
+		res = append(res, s)
+	}
+
+	return res, nil
+}
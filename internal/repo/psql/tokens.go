@@ -0,0 +1,176 @@
+package psql
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/n-r-w/eno"
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/sqlb"
+	"github.com/n-r-w/sqlq"
+	"github.com/n-r-w/tools"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+const tokenFields = `id, create_time, rotate_time, name, secret_hash, scopes, channels,
+	COALESCE(EXTRACT(EPOCH FROM expire_time), 0)::bigint AS expire_unix,
+	CASE WHEN revoked THEN 1 ELSE 0 END AS revoked`
+
+// Tokens все токены доступа
+func (p *Repo) Tokens(ctx context.Context) ([]entity.Token, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbReadTimeout))
+	defer cancel()
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	sql := `SELECT ` + tokenFields + ` FROM tokens ORDER BY name`
+	q, err := sqlq.SelectTx(tx, sql)
+	if err != nil {
+		return nil, nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	res := []entity.Token{}
+	for q.Next() {
+		res = append(res, readToken(q))
+	}
+
+	return res, nil
+}
+
+// CreateToken создать токен доступа
+func (p *Repo) CreateToken(token entity.Token, ctx context.Context) (entity.Token, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbWriteTimeout))
+	defer cancel()
+
+	var expireUnix int64
+	if !token.ExpireTime.IsZero() {
+		expireUnix = token.ExpireTime.Unix()
+	}
+
+	sql, err := sqlb.Bind(
+		`INSERT INTO public.tokens(name, secret_hash, scopes, channels, expire_time)
+			VALUES (:name, :secret_hash, :scopes, :channels, CASE WHEN :expire_unix = 0 THEN NULL ELSE to_timestamp(:expire_unix) END)
+		RETURNING `+tokenFields,
+		map[string]interface{}{
+			"name":        token.Name,
+			"secret_hash": token.SecretHash,
+			"scopes":      entity.ListString(token.Scopes),
+			"channels":    entity.ListString(token.Channels),
+			"expire_unix": expireUnix,
+		}, "CreateToken")
+	if err != nil {
+		return entity.Token{}, err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return entity.Token{}, err
+	}
+	defer tx.Rollback()
+
+	q, err := sqlq.SelectTxRow(tx, sql)
+	if err != nil {
+		if nerr.SqlCode(err) == pgerrcode.UniqueViolation {
+			// такой токен уже есть
+			return entity.Token{}, nerr.New(eno.ErrObjectExist)
+		}
+		return entity.Token{}, nerr.New(err, tools.SimplifyString(sql))
+	}
+	res := readToken(q)
+
+	return res, tx.Commit()
+}
+
+// RotateToken сменить значение токена доступа. Возвращает false, если токен не найден
+func (p *Repo) RotateToken(name string, secretHash string, ctx context.Context) (bool, entity.Token, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbWriteTimeout))
+	defer cancel()
+
+	sql, err := sqlb.Bind(
+		`UPDATE public.tokens SET secret_hash = :secret_hash, rotate_time = now()
+		WHERE name = :name AND NOT revoked
+		RETURNING `+tokenFields,
+		map[string]interface{}{
+			"name":        name,
+			"secret_hash": secretHash,
+		}, "RotateToken")
+	if err != nil {
+		return false, entity.Token{}, err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return false, entity.Token{}, err
+	}
+	defer tx.Rollback()
+
+	q, err := sqlq.SelectTxRow(tx, sql)
+	if err != nil {
+		return false, entity.Token{}, nerr.New(err, tools.SimplifyString(sql))
+	}
+	if q == nil {
+		return false, entity.Token{}, nil
+	}
+	res := readToken(q)
+
+	return true, res, tx.Commit()
+}
+
+// RevokeToken отозвать токен доступа. Возвращает false, если токен не найден
+func (p *Repo) RevokeToken(name string, ctx context.Context) (bool, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbWriteTimeout))
+	defer cancel()
+
+	sql, err := sqlb.BindOne(
+		`UPDATE public.tokens SET revoked = true WHERE name = :name RETURNING id`,
+		"name", name, "RevokeToken")
+	if err != nil {
+		return false, err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	q, err := sqlq.SelectTxRow(tx, sql)
+	if err != nil {
+		return false, nerr.New(err, tools.SimplifyString(sql))
+	}
+	if q == nil {
+		return false, nil
+	}
+
+	return true, tx.Commit()
+}
+
+// строка результата запроса
+type queryRow interface {
+	UInt64(name string) uint64
+	Int(name string) int
+	String(name string) string
+	Time(name string) time.Time
+}
+
+func readToken(q queryRow) entity.Token {
+	t := entity.Token{
+		ID:         q.UInt64("id"),
+		CreateTime: q.Time("create_time"),
+		RotateTime: q.Time("rotate_time"),
+		Name:       q.String("name"),
+		SecretHash: q.String("secret_hash"),
+		Scopes:     entity.ParseList(q.String("scopes")),
+		Channels:   entity.ParseList(q.String("channels")),
+		Revoked:    q.Int("revoked") != 0,
+	}
+	if expire := q.UInt64("expire_unix"); expire > 0 {
+		t.ExpireTime = time.Unix(int64(expire), 0)
+	}
+	return t
+}
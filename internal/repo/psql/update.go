@@ -9,7 +9,7 @@ import (
 )
 
 // Update получить обновление
-func (p *Repo) Update(сhannel string, version entity.Version, ctx context.Context) ([]byte, entity.UpdateInfo, error) {
+func (p *Repo) Update(сhannel string, version entity.Version, format string, ctx context.Context) ([]byte, entity.UpdateInfo, error) {
 	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbWriteTimeout))
 	defer cancel()
 
@@ -22,15 +22,21 @@ func (p *Repo) Update(сhannel string, version entity.Version, ctx context.Conte
 		return nil, entity.UpdateInfo{}, nil
 	}
 
-	res, zipData, err := p.cache.Get(processVersion{
-		fromC: сhannel,
-		fromV: version,
-		toC:   toI.Channel,
-		toV:   toI.Version,
+	res, pkgData, err := p.cache.Get(processVersion{
+		fromC:  сhannel,
+		fromV:  version,
+		toC:    toI.Channel,
+		toV:    toI.Version,
+		format: format,
 	}, ctxChild)
 	if err != nil {
 		return nil, entity.UpdateInfo{}, err
 	}
+	if res == nil {
+		return nil, entity.UpdateInfo{}, nil
+	}
+
+	p.metrics.BytesServed.WithLabelValues(сhannel).Add(float64(len(pkgData)))
 
-	return zipData, *res, nil
+	return pkgData, *res, nil
 }
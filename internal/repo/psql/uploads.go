@@ -0,0 +1,299 @@
+package psql
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/n-r-w/eno"
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/sqlb"
+	"github.com/n-r-w/sqlq"
+	"github.com/n-r-w/tools"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+const uploadFields = `id, token, create_time, expire_time, channel, major, minor, patch, revision, info, size, checksum,
+	CASE WHEN enabled THEN 1 ELSE 0 END AS enabled,
+	COALESCE(EXTRACT(EPOCH FROM build_time), 0)::bigint AS build_unix`
+
+// CreateUpload создать сессию загрузки обновления частями
+func (p *Repo) CreateUpload(session entity.UploadSession, ctx context.Context) error {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbWriteTimeout))
+	defer cancel()
+
+	var buildUnix int64
+	if !session.BuildTime.IsZero() {
+		buildUnix = session.BuildTime.Unix()
+	}
+
+	sql, err := sqlb.Bind(
+		`INSERT INTO public.upload_sessions(id, token, expire_time, channel, major, minor, patch, revision, info, enabled, build_time, size, checksum)
+			VALUES (:id, :token, to_timestamp(:expire_unix), :channel, :major, :minor, :patch, :revision, :info, :enabled,
+				CASE WHEN :build_unix = 0 THEN NULL ELSE to_timestamp(:build_unix) END, :size, :checksum)`,
+		map[string]interface{}{
+			"id":          session.ID,
+			"token":       session.Token,
+			"expire_unix": session.ExpireTime.Unix(),
+			"channel":     session.Channel,
+			"major":       session.Version.Major,
+			"minor":       session.Version.Minor,
+			"patch":       session.Version.Patch,
+			"revision":    session.Version.Revision,
+			"info":        session.Info,
+			"enabled":     session.Enabled,
+			"build_unix":  buildUnix,
+			"size":        session.Size,
+			"checksum":    session.Checksum,
+		}, "CreateUpload")
+	if err != nil {
+		return err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = sqlq.ExecTx(tx, sql); err != nil {
+		if nerr.SqlCode(err) == pgerrcode.UniqueViolation {
+			return nerr.New(eno.ErrObjectExist)
+		}
+		return nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	return tx.Commit()
+}
+
+// Upload сессия загрузки с полученными частями. Возвращает false, если сессии нет, она истекла или создана другим токеном
+func (p *Repo) Upload(id string, token string, ctx context.Context) (bool, entity.UploadSession, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbReadTimeout))
+	defer cancel()
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return false, entity.UploadSession{}, err
+	}
+	defer tx.Rollback()
+
+	sql, err := sqlb.Bind(
+		`SELECT `+uploadFields+` FROM public.upload_sessions
+		WHERE id = :id AND token = :token AND expire_time > now()`,
+		map[string]interface{}{
+			"id":    id,
+			"token": token,
+		}, "Upload")
+	if err != nil {
+		return false, entity.UploadSession{}, err
+	}
+
+	q, err := sqlq.SelectTxRow(tx, sql)
+	if err != nil {
+		return false, entity.UploadSession{}, nerr.New(err, tools.SimplifyString(sql))
+	}
+	if q == nil {
+		return false, entity.UploadSession{}, nil
+	}
+
+	res := entity.UploadSession{
+		ID:         q.String("id"),
+		Token:      q.String("token"),
+		CreateTime: q.Time("create_time"),
+		ExpireTime: q.Time("expire_time"),
+		Channel:    q.String("channel"),
+		Version: entity.Version{
+			Major:    q.Int("major"),
+			Minor:    q.Int("minor"),
+			Patch:    q.Int("patch"),
+			Revision: q.Int("revision"),
+		},
+		Info:     q.String("info"),
+		Enabled:  q.Int("enabled") == 1,
+		Size:     int64(q.UInt64("size")),
+		Checksum: q.String("checksum"),
+	}
+	if buildUnix := q.Int("build_unix"); buildUnix > 0 {
+		res.BuildTime = time.Unix(int64(buildUnix), 0)
+	}
+
+	// части
+	sql, err = sqlb.BindOne(
+		`SELECT number, size, checksum, data_oid FROM public.upload_chunks WHERE id_session = :id ORDER BY number`,
+		"id", id, "UploadChunks")
+	if err != nil {
+		return false, entity.UploadSession{}, err
+	}
+	if q, err = sqlq.SelectTx(tx, sql); err != nil {
+		return false, entity.UploadSession{}, nerr.New(err, tools.SimplifyString(sql))
+	}
+	for q.Next() {
+		res.Chunks = append(res.Chunks, entity.UploadChunk{
+			Number:   q.Int("number"),
+			Size:     int64(q.UInt64("size")),
+			Checksum: q.String("checksum"),
+			DataID:   uint32(q.UInt64("data_oid")),
+		})
+	}
+	res.UpdateProgress()
+
+	return true, res, nil
+}
+
+// AddUploadChunk сохранить часть архива. Часть с тем же номером заменяется, время жизни сессии продлевается.
+// Возвращает false и общий размер частей с учетом новой, если он больше maxSize или размера, заявленного при создании сессии
+func (p *Repo) AddUploadChunk(id string, chunk entity.UploadChunk, data []byte, maxSize int64, expireTime time.Time, ctx context.Context) (bool, int64, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbWriteTimeout))
+	defer cancel()
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	// блокировка сессии до конца транзакции: параллельные части проверяют размер по очереди
+	sql, err := sqlb.BindOne(`SELECT size FROM public.upload_sessions WHERE id = :id FOR UPDATE`, "id", id, "LockUpload")
+	if err != nil {
+		return false, 0, err
+	}
+	q, err := sqlq.SelectTxRow(tx, sql)
+	if err != nil {
+		return false, 0, nerr.New(err, tools.SimplifyString(sql))
+	}
+	if q == nil {
+		return false, 0, nerr.New(eno.ErrNotFound)
+	}
+	declared := int64(q.UInt64("size"))
+
+	// общий размер с учетом замены части с тем же номером
+	sql, err = sqlb.Bind(
+		`SELECT COALESCE(SUM(size), 0)::bigint AS total FROM public.upload_chunks WHERE id_session = :id AND number <> :number`,
+		map[string]interface{}{
+			"id":     id,
+			"number": chunk.Number,
+		}, "UploadSize")
+	if err != nil {
+		return false, 0, err
+	}
+	if q, err = sqlq.SelectTxRow(tx, sql); err != nil {
+		return false, 0, nerr.New(err, tools.SimplifyString(sql))
+	}
+	total := int64(q.UInt64("total")) + chunk.Size
+	if total > maxSize || (declared > 0 && total > declared) {
+		return false, total, nil
+	}
+
+	oid, err := sqlq.SaveLargeObject(tx, 0, data)
+	if err != nil {
+		return false, 0, nerr.New(err)
+	}
+
+	// старый large object при замене удаляется триггером t_upload_chunks_clear_data
+	sql, err = sqlb.Bind(
+		`INSERT INTO public.upload_chunks(id_session, number, size, checksum, data_oid)
+			VALUES (:id_session, :number, :size, :checksum, :data_oid)
+		ON CONFLICT (id_session, number) DO UPDATE SET size = EXCLUDED.size, checksum = EXCLUDED.checksum, data_oid = EXCLUDED.data_oid`,
+		map[string]interface{}{
+			"id_session": id,
+			"number":     chunk.Number,
+			"size":       chunk.Size,
+			"checksum":   chunk.Checksum,
+			"data_oid":   oid,
+		}, "AddUploadChunk")
+	if err != nil {
+		return false, 0, err
+	}
+	if _, err = sqlq.ExecTx(tx, sql); err != nil {
+		return false, 0, nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	sql, err = sqlb.Bind(
+		`UPDATE public.upload_sessions SET expire_time = to_timestamp(:expire_unix) WHERE id = :id`,
+		map[string]interface{}{
+			"id":          id,
+			"expire_unix": expireTime.Unix(),
+		}, "ProlongUpload")
+	if err != nil {
+		return false, 0, err
+	}
+	if _, err = sqlq.ExecTx(tx, sql); err != nil {
+		return false, 0, nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, 0, err
+	}
+
+	return true, total, nil
+}
+
+// ReadUpload запись частей архива по порядку в w
+func (p *Repo) ReadUpload(session entity.UploadSession, w io.Writer, ctx context.Context) error {
+	tx := sqlq.NewTx(p.Pool, ctx) // для загрузки LO
+	if err := tx.Begin(); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, chunk := range session.Chunks {
+		data, err := sqlq.LoadLargeObject(tx, chunk.DataID)
+		if err != nil {
+			return nerr.New(err)
+		}
+		if _, err = w.Write(data); err != nil {
+			return nerr.New(err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteUpload удалить сессию загрузки. Возвращает false, если сессия не найдена
+func (p *Repo) DeleteUpload(id string, ctx context.Context) (bool, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbWriteTimeout))
+	defer cancel()
+
+	sql, err := sqlb.BindOne(`DELETE FROM public.upload_sessions WHERE id = :id RETURNING id`, "id", id, "DeleteUpload")
+	if err != nil {
+		return false, err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	q, err := sqlq.SelectTxRow(tx, sql)
+	if err != nil {
+		return false, nerr.New(err, tools.SimplifyString(sql))
+	}
+	if q == nil {
+		return false, nil
+	}
+
+	return true, tx.Commit()
+}
+
+// ExpireUploads удалить истекшие сессии загрузки. Возвращает количество удаленных
+func (p *Repo) ExpireUploads(ctx context.Context) (int, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbWriteTimeout))
+	defer cancel()
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// large object частей удаляются триггером t_upload_chunks_clear_data
+	sql := `DELETE FROM public.upload_sessions WHERE expire_time <= now()`
+	count, err := sqlq.ExecTx(tx, sql)
+	if err != nil {
+		return 0, nerr.New(err, sql)
+	}
+
+	return int(count), tx.Commit()
+}
@@ -0,0 +1,103 @@
+package psql
+
+import (
+	"context"
+	"time"
+
+	"github.com/n-r-w/nerr"
+	"github.com/n-r-w/sqlb"
+	"github.com/n-r-w/sqlq"
+	"github.com/n-r-w/tools"
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// Versions все версии канала без информации о файлах, от новых к старым
+func (p *Repo) Versions(сhannel string, ctx context.Context) ([]entity.UpdateInfo, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbReadTimeout))
+	defer cancel()
+
+	sql, err := sqlb.BindOne(
+		`SELECT id, record_time, channel, major, minor, patch, revision, build_time, COALESCE(info, '') AS info,
+			CASE WHEN enabled THEN 1 ELSE 0 END AS enabled
+		FROM updates
+		WHERE channel = :channel
+		ORDER BY major DESC, minor DESC, patch DESC, revision DESC`,
+		"channel", сhannel, "Versions")
+	if err != nil {
+		return nil, err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	q, err := sqlq.SelectTx(tx, sql)
+	if err != nil {
+		return nil, nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	res := []entity.UpdateInfo{}
+	for q.Next() {
+		res = append(res, entity.UpdateInfo{
+			ID:         q.UInt64("id"),
+			CreateTime: q.Time("record_time"),
+			BuildTime:  q.Time("build_time"),
+			Channel:    q.String("channel"),
+			Version: entity.Version{
+				Major:    q.Int("major"),
+				Minor:    q.Int("minor"),
+				Patch:    q.Int("patch"),
+				Revision: q.Int("revision"),
+			},
+			Info:    q.String("info"),
+			Enabled: q.Int("enabled") == 1,
+		})
+	}
+
+	return res, nil
+}
+
+// VersionFiles файлы версии без содержимого, в том числе выключенной. Возвращает false, если версии нет
+func (p *Repo) VersionFiles(сhannel string, version entity.Version, ctx context.Context) (bool, []entity.FileInfo, error) {
+	ctxChild, cancel := context.WithTimeout(ctx, time.Second*time.Duration(p.config.DbReadTimeout))
+	defer cancel()
+
+	sql, err := sqlb.Bind(
+		`SELECT id FROM updates
+		WHERE channel = :channel AND major = :major AND minor = :minor AND patch = :patch AND revision = :revision`,
+		map[string]interface{}{
+			"channel":  сhannel,
+			"major":    version.Major,
+			"minor":    version.Minor,
+			"patch":    version.Patch,
+			"revision": version.Revision,
+		}, "VersionFiles")
+	if err != nil {
+		return false, nil, err
+	}
+
+	tx := sqlq.NewTx(p.Pool, ctxChild)
+	if err := tx.Begin(); err != nil {
+		return false, nil, err
+	}
+	defer tx.Rollback()
+
+	q, err := sqlq.SelectTxRow(tx, sql)
+	if err != nil {
+		return false, nil, nerr.New(err, tools.SimplifyString(sql))
+	}
+	if q == nil {
+		return false, nil, nil
+	}
+
+	if sql, err = sqlb.BindOne(filesQuery, "id_update", q.UInt64("id"), "VersionFilesList"); err != nil {
+		return false, nil, err
+	}
+	if q, err = sqlq.SelectTx(tx, sql); err != nil {
+		return false, nil, nerr.New(err, tools.SimplifyString(sql))
+	}
+
+	return true, readFiles(q), nil
+}
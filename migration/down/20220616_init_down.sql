@@ -0,0 +1,9 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+-- DROP TABLE не вызывает триггеры, поэтому large object удаляем явно
+SELECT lo_unlink(diff_oid) FROM public.cache WHERE diff_oid IS NOT NULL;
+SELECT lo_unlink(data_oid) FROM public.files;
+
+DROP TABLE public.cache;
+DROP TABLE public.files;
+DROP TABLE public.updates;
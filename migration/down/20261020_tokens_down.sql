@@ -0,0 +1,3 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+DROP TABLE public.tokens;
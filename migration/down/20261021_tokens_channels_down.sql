@@ -0,0 +1,3 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+ALTER TABLE public.tokens DROP COLUMN channels;
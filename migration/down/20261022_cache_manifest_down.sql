@@ -0,0 +1,4 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+-- кэш не восстанавливается, архивы с манифестом совместимы со старой версией сервера
+SELECT 1;
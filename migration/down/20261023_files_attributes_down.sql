@@ -0,0 +1,7 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+ALTER TABLE public.files DROP COLUMN link_target;
+ALTER TABLE public.files DROP COLUMN mod_time;
+ALTER TABLE public.files DROP COLUMN mode;
+
+DELETE FROM public.cache;
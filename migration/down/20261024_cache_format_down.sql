@@ -0,0 +1,11 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+-- large object удаляются триггером t_cache_clear_data
+DELETE FROM public.cache WHERE format <> 'zip';
+
+DROP INDEX public.uk_cache;
+CREATE UNIQUE INDEX uk_cache ON public.cache (COALESCE(id_update_from,-1), id_update_to);
+
+ALTER TABLE public.cache DROP COLUMN format;
+
+COMMENT ON COLUMN public.cache.diff_oid IS 'ссылка на large object c zip архивом diff';
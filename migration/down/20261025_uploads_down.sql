@@ -0,0 +1,7 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+-- large object удаляются триггером t_upload_chunks_clear_data
+DELETE FROM public.upload_chunks;
+
+DROP TABLE public.upload_chunks;
+DROP TABLE public.upload_sessions;
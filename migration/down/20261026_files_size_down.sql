@@ -0,0 +1,3 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+ALTER TABLE public.files DROP COLUMN size;
@@ -0,0 +1,12 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+DROP TRIGGER t_files_clear_data ON public.files;
+DROP FUNCTION public.files_unlink_data();
+
+-- lo_manage требует отдельный large object у каждой строки: общие копируются
+UPDATE public.files f SET data_oid = lo_from_bytea(0, lo_get(f.data_oid))
+WHERE EXISTS (SELECT 1 FROM public.files o WHERE o.data_oid = f.data_oid AND o.ctid < f.ctid);
+
+DROP INDEX public.idx_files_data_oid;
+
+CREATE TRIGGER t_files_clear_data BEFORE UPDATE OR DELETE ON public.files FOR EACH ROW EXECUTE FUNCTION lo_manage(data_oid);
@@ -0,0 +1,51 @@
+// Package migration Скрипты изменения схемы БД, встроенные в исполняемый файл
+package migration
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+//go:embed up/*.sql down/*.sql
+var scripts embed.FS
+
+// Migration скрипты одной версии схемы БД
+type Migration struct {
+	Version string // имя файла без суффикса _up.sql, например 20220616_init
+	Up      string
+	Down    string
+}
+
+// List все миграции в порядке применения
+func List() ([]Migration, error) {
+	files, err := fs.Glob(scripts, "up/*_up.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	res := make([]Migration, 0, len(files))
+	for _, f := range files {
+		version := strings.TrimSuffix(strings.TrimPrefix(f, "up/"), "_up.sql")
+
+		up, err := scripts.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		down, err := scripts.ReadFile("down/" + version + "_down.sql")
+		if err != nil {
+			return nil, fmt.Errorf("no down migration for %s: %v", version, err)
+		}
+
+		res = append(res, Migration{
+			Version: version,
+			Up:      string(up),
+			Down:    string(down),
+		})
+	}
+
+	return res, nil
+}
@@ -0,0 +1,31 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+CREATE TABLE public.audit
+(
+    id bigserial NOT NULL,
+    record_time timestamp with time zone NOT NULL DEFAULT Now(),
+    operation text NOT NULL,
+    token_name text NOT NULL,
+    ip text,
+    real_ip text,
+    channel text,
+    params jsonb NOT NULL DEFAULT '{}',
+    status integer NOT NULL,
+    result text,
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX idx_audit_time ON public.audit (record_time);
+CREATE INDEX idx_audit_operation ON public.audit (operation, channel);
+
+COMMENT ON TABLE public.audit IS 'журнал административных операций';
+COMMENT ON COLUMN public.audit.record_time IS 'время операции';
+COMMENT ON COLUMN public.audit.operation IS 'операция';
+COMMENT ON COLUMN public.audit.token_name IS 'имя токена доступа, которым выполнена операция';
+COMMENT ON COLUMN public.audit.ip IS 'ip адрес клиента';
+COMMENT ON COLUMN public.audit.real_ip IS 'ip адрес клиента с учетом прокси';
+COMMENT ON COLUMN public.audit.channel IS 'канал обновлений';
+COMMENT ON COLUMN public.audit.params IS 'параметры операции';
+COMMENT ON COLUMN public.audit.status IS 'http код ответа';
+COMMENT ON COLUMN public.audit.result IS 'текст ошибки, если операция не выполнена';
@@ -0,0 +1,38 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+CREATE TABLE public.clients
+(
+    id text NOT NULL,
+    first_seen timestamp with time zone NOT NULL DEFAULT Now(),
+    last_seen timestamp with time zone NOT NULL DEFAULT Now(),
+    channel text NOT NULL,
+    major integer NOT NULL,
+    minor integer NOT NULL DEFAULT 0,
+    patch integer NOT NULL DEFAULT 0,
+    revision integer NOT NULL DEFAULT 0,
+    ip text,
+    real_ip text,
+    local_ip text,
+    app_login text,
+    os_login text,
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX idx_clients_version ON public.clients (channel, major, minor, patch, revision);
+CREATE INDEX idx_clients_last_seen ON public.clients (last_seen);
+
+COMMENT ON TABLE public.clients IS 'последнее известное состояние клиентов';
+COMMENT ON COLUMN public.clients.id IS 'идентификатор клиента: переданный клиентом или вычисленный по ip адресам и логину в ОС';
+COMMENT ON COLUMN public.clients.first_seen IS 'время первого обращения';
+COMMENT ON COLUMN public.clients.last_seen IS 'время последнего обращения';
+COMMENT ON COLUMN public.clients.channel IS 'канал обновлений';
+COMMENT ON COLUMN public.clients.major IS 'установленная версия major';
+COMMENT ON COLUMN public.clients.minor IS 'установленная версия minor';
+COMMENT ON COLUMN public.clients.patch IS 'установленная версия patch';
+COMMENT ON COLUMN public.clients.revision IS 'установленная версия revision';
+COMMENT ON COLUMN public.clients.ip IS 'ip адрес клиента';
+COMMENT ON COLUMN public.clients.real_ip IS 'ip адрес клиента с учетом прокси';
+COMMENT ON COLUMN public.clients.local_ip IS 'локальный ip адрес клиента';
+COMMENT ON COLUMN public.clients.app_login IS 'логин пользователя в приложении';
+COMMENT ON COLUMN public.clients.os_login IS 'логин пользователя в ОС';
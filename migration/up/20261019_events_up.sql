@@ -0,0 +1,43 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+CREATE TABLE public.events
+(
+    id bigserial NOT NULL,
+    record_time timestamp with time zone NOT NULL DEFAULT Now(),
+    event text NOT NULL,
+    channel text NOT NULL,
+    from_major integer NOT NULL,
+    from_minor integer NOT NULL DEFAULT 0,
+    from_patch integer NOT NULL DEFAULT 0,
+    from_revision integer NOT NULL DEFAULT 0,
+    to_major integer NOT NULL,
+    to_minor integer NOT NULL DEFAULT 0,
+    to_patch integer NOT NULL DEFAULT 0,
+    to_revision integer NOT NULL DEFAULT 0,
+    bytes bigint NOT NULL DEFAULT 0,
+    duration integer NOT NULL DEFAULT 0,
+    ip text,
+    real_ip text,
+    local_ip text,
+    app_login text,
+    os_login text,
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX idx_events_time ON public.events (record_time);
+CREATE INDEX idx_events_version ON public.events (channel, to_major, to_minor, to_patch, to_revision);
+
+COMMENT ON TABLE public.events IS 'события выдачи обновлений';
+COMMENT ON COLUMN public.events.record_time IS 'время события';
+COMMENT ON COLUMN public.events.event IS 'вид события: check - проверка, cache - выдача из кэша, diff - вычисление дельты, full - выдача полного пакета';
+COMMENT ON COLUMN public.events.channel IS 'канал обновлений';
+COMMENT ON COLUMN public.events.from_major IS 'версия клиента major';
+COMMENT ON COLUMN public.events.to_major IS 'версия обновления major. Если обновление не найдено, то совпадает с версией клиента';
+COMMENT ON COLUMN public.events.bytes IS 'размер выданного архива';
+COMMENT ON COLUMN public.events.duration IS 'длительность обработки в миллисекундах';
+COMMENT ON COLUMN public.events.ip IS 'ip адрес клиента';
+COMMENT ON COLUMN public.events.real_ip IS 'ip адрес клиента с учетом прокси';
+COMMENT ON COLUMN public.events.local_ip IS 'локальный ip адрес клиента';
+COMMENT ON COLUMN public.events.app_login IS 'логин пользователя в приложении';
+COMMENT ON COLUMN public.events.os_login IS 'логин пользователя в ОС';
@@ -0,0 +1,43 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+CREATE TABLE public.reports
+(
+    id bigserial NOT NULL,
+    record_time timestamp with time zone NOT NULL DEFAULT Now(),
+    channel text NOT NULL,
+    from_major integer NOT NULL,
+    from_minor integer NOT NULL DEFAULT 0,
+    from_patch integer NOT NULL DEFAULT 0,
+    from_revision integer NOT NULL DEFAULT 0,
+    to_major integer NOT NULL,
+    to_minor integer NOT NULL DEFAULT 0,
+    to_patch integer NOT NULL DEFAULT 0,
+    to_revision integer NOT NULL DEFAULT 0,
+    success boolean NOT NULL,
+    error text,
+    duration integer NOT NULL DEFAULT 0,
+    ip text,
+    real_ip text,
+    local_ip text,
+    app_login text,
+    os_login text,
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX idx_reports_time ON public.reports (record_time);
+CREATE INDEX idx_reports_version ON public.reports (channel, to_major, to_minor, to_patch, to_revision);
+
+COMMENT ON TABLE public.reports IS 'результаты установки обновлений на клиентах';
+COMMENT ON COLUMN public.reports.record_time IS 'время получения отчета';
+COMMENT ON COLUMN public.reports.channel IS 'канал обновлений';
+COMMENT ON COLUMN public.reports.from_major IS 'версия клиента до обновления major';
+COMMENT ON COLUMN public.reports.to_major IS 'устанавливаемая версия major';
+COMMENT ON COLUMN public.reports.success IS 'обновление установлено успешно';
+COMMENT ON COLUMN public.reports.error IS 'текст ошибки установки';
+COMMENT ON COLUMN public.reports.duration IS 'длительность установки в миллисекундах';
+COMMENT ON COLUMN public.reports.ip IS 'ip адрес клиента';
+COMMENT ON COLUMN public.reports.real_ip IS 'ip адрес клиента с учетом прокси';
+COMMENT ON COLUMN public.reports.local_ip IS 'локальный ip адрес клиента';
+COMMENT ON COLUMN public.reports.app_login IS 'логин пользователя в приложении';
+COMMENT ON COLUMN public.reports.os_login IS 'логин пользователя в ОС';
@@ -0,0 +1,27 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+CREATE TABLE public.tokens
+(
+    id bigserial NOT NULL,
+    create_time timestamp with time zone NOT NULL DEFAULT Now(),
+    rotate_time timestamp with time zone NOT NULL DEFAULT Now(),
+    name text NOT NULL,
+    secret_hash text NOT NULL,
+    scopes text NOT NULL,
+    expire_time timestamp with time zone,
+    revoked boolean NOT NULL DEFAULT false,
+
+    PRIMARY KEY (id)
+);
+
+ALTER TABLE IF EXISTS public.tokens ADD CONSTRAINT uk_tokens_name UNIQUE (name);
+ALTER TABLE IF EXISTS public.tokens ADD CONSTRAINT uk_tokens_secret UNIQUE (secret_hash);
+
+COMMENT ON TABLE public.tokens IS 'токены доступа';
+COMMENT ON COLUMN public.tokens.create_time IS 'время создания';
+COMMENT ON COLUMN public.tokens.rotate_time IS 'время последней смены значения';
+COMMENT ON COLUMN public.tokens.name IS 'имя токена (например, название заказчика)';
+COMMENT ON COLUMN public.tokens.secret_hash IS 'sha256 от значения токена';
+COMMENT ON COLUMN public.tokens.scopes IS 'права через запятую: read, write, admin';
+COMMENT ON COLUMN public.tokens.expire_time IS 'время окончания действия. NULL - бессрочный';
+COMMENT ON COLUMN public.tokens.revoked IS 'токен отозван';
@@ -0,0 +1,5 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+ALTER TABLE public.tokens ADD COLUMN channels text NOT NULL DEFAULT '';
+
+COMMENT ON COLUMN public.tokens.channels IS 'разрешенные каналы или шаблоны вида HRFILE_* через запятую. Пустая строка - все каналы';
@@ -0,0 +1,5 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+-- архивы в кэше созданы без .update_manifest.json, будут пересозданы при следующем запросе.
+-- large object удаляются триггером t_cache_clear_data
+DELETE FROM public.cache;
@@ -0,0 +1,12 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+ALTER TABLE public.files ADD COLUMN mode integer NOT NULL DEFAULT 420;
+ALTER TABLE public.files ADD COLUMN mod_time timestamp with time zone;
+ALTER TABLE public.files ADD COLUMN link_target text NOT NULL DEFAULT '';
+
+COMMENT ON COLUMN public.files.mode IS 'права доступа unix (420 = 0644)';
+COMMENT ON COLUMN public.files.mod_time IS 'время изменения файла из архива';
+COMMENT ON COLUMN public.files.link_target IS 'для символической ссылки - куда она указывает. Пустая строка - обычный файл';
+
+-- архивы в кэше созданы без прав доступа и ссылок, будут пересозданы при следующем запросе
+DELETE FROM public.cache;
@@ -0,0 +1,10 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+-- один и тот же diff может храниться в кэше в нескольких форматах
+ALTER TABLE public.cache ADD COLUMN format text NOT NULL DEFAULT 'zip';
+
+DROP INDEX public.uk_cache;
+CREATE UNIQUE INDEX uk_cache ON public.cache (COALESCE(id_update_from,-1), id_update_to, format);
+
+COMMENT ON COLUMN public.cache.format IS 'формат архива: zip, tar.zst';
+COMMENT ON COLUMN public.cache.diff_oid IS 'ссылка на large object c архивом diff в формате format';
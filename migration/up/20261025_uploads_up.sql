@@ -0,0 +1,50 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+CREATE TABLE public.upload_sessions
+(
+    id text NOT NULL,
+    token text NOT NULL,
+    create_time timestamp with time zone NOT NULL DEFAULT Now(),
+    expire_time timestamp with time zone NOT NULL,
+    channel text NOT NULL,
+    major integer NOT NULL,
+    minor integer NOT NULL,
+    patch integer NOT NULL,
+    revision integer NOT NULL,
+    info text NOT NULL DEFAULT '',
+    enabled boolean NOT NULL DEFAULT true,
+    build_time timestamp with time zone,
+    size bigint NOT NULL DEFAULT 0,
+    checksum text NOT NULL DEFAULT '',
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX idx_upload_sessions_expire ON public.upload_sessions (expire_time);
+
+COMMENT ON TABLE public.upload_sessions IS 'сессии загрузки обновлений частями';
+COMMENT ON COLUMN public.upload_sessions.token IS 'имя токена, создавшего сессию';
+COMMENT ON COLUMN public.upload_sessions.expire_time IS 'время, после которого незавершенная сессия удаляется';
+COMMENT ON COLUMN public.upload_sessions.build_time IS 'время сборки. NULL - время завершения загрузки';
+COMMENT ON COLUMN public.upload_sessions.size IS 'ожидаемый размер архива. 0 - не проверяется';
+COMMENT ON COLUMN public.upload_sessions.checksum IS 'ожидаемый sha256 архива. Пустая строка - не проверяется';
+
+CREATE TABLE public.upload_chunks
+(
+    id_session text NOT NULL,
+    number integer NOT NULL,
+    size bigint NOT NULL,
+    checksum text NOT NULL,
+    data_oid oid NOT NULL,
+
+    PRIMARY KEY (id_session, number),
+    CONSTRAINT fk_upload_chunks_session FOREIGN KEY (id_session) REFERENCES public.upload_sessions (id) MATCH SIMPLE ON UPDATE NO ACTION ON DELETE CASCADE
+);
+
+-- очистка large object при изменениях
+CREATE TRIGGER t_upload_chunks_clear_data BEFORE UPDATE OR DELETE ON public.upload_chunks FOR EACH ROW EXECUTE FUNCTION lo_manage(data_oid);
+
+COMMENT ON TABLE public.upload_chunks IS 'полученные части архивов сессий загрузки';
+COMMENT ON COLUMN public.upload_chunks.number IS 'номер части с 0';
+COMMENT ON COLUMN public.upload_chunks.checksum IS 'sha256 части';
+COMMENT ON COLUMN public.upload_chunks.data_oid IS 'ссылка на large object с содержимым части';
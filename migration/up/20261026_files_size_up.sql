@@ -0,0 +1,20 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+ALTER TABLE public.files ADD COLUMN size bigint NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN public.files.size IS 'размер файла в байтах';
+
+-- размер определяется переходом в конец large object, без чтения содержимого в память.
+-- data_oid не меняется, поэтому триггер lo_manage содержимое не удаляет
+DO $$
+DECLARE
+    r record;
+    fd integer;
+BEGIN
+    FOR r IN SELECT id_update, file_name, data_oid FROM public.files LOOP
+        fd := lo_open(r.data_oid, 262144); -- INV_READ
+        UPDATE public.files SET size = lo_lseek64(fd, 0, 2) -- SEEK_END
+            WHERE id_update = r.id_update AND file_name = r.file_name;
+        PERFORM lo_close(fd);
+    END LOOP;
+END $$;
@@ -0,0 +1,6 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+-- архивы, собранные до появления поля, считаются устаревшими и пересобираются при следующем запросе
+ALTER TABLE public.cache ADD COLUMN compression text NOT NULL DEFAULT '';
+
+COMMENT ON COLUMN public.cache.compression IS 'настройки сжатия, с которыми собран архив. При изменении COMPRESSION архив пересобирается';
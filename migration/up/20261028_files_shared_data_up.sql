@@ -0,0 +1,29 @@
+SET CLIENT_ENCODING TO 'UTF8';
+
+-- при наложении неизмененные файлы новой версии ссылаются на large object базовой версии, а не на копию.
+-- lo_manage удалил бы общий large object вместе с первой версией, поэтому он удаляется,
+-- только когда на него не осталось ссылок в files
+DROP TRIGGER t_files_clear_data ON public.files;
+
+CREATE INDEX idx_files_data_oid ON public.files (data_oid);
+
+CREATE FUNCTION public.files_unlink_data() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.data_oid = OLD.data_oid THEN
+        RETURN NULL;
+    END IF;
+
+    -- параллельные транзакции, удаляющие или добавляющие ссылки на тот же large object, проверяют их по очереди.
+    -- Ключ - oid: он меньше 2^32 и не совпадает с ключом блокировки миграций
+    PERFORM pg_advisory_xact_lock(OLD.data_oid::bigint);
+
+    -- в одной команде может удаляться несколько ссылок: large object удаляет первая, остальные его уже не находят
+    IF NOT EXISTS (SELECT 1 FROM public.files WHERE data_oid = OLD.data_oid)
+        AND EXISTS (SELECT 1 FROM pg_largeobject_metadata WHERE oid = OLD.data_oid) THEN
+        PERFORM lo_unlink(OLD.data_oid);
+    END IF;
+
+    RETURN NULL;
+END $$;
+
+CREATE TRIGGER t_files_clear_data AFTER UPDATE OR DELETE ON public.files FOR EACH ROW EXECUTE FUNCTION public.files_unlink_data();
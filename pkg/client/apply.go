@@ -0,0 +1,425 @@
+package client
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// DiffInfoFile файл в архиве обновления со списком изменений: строки вида "+ имя", "* имя", "- имя".
+// Оставлен для совместимости, новые серверы добавляют манифест
+const DiffInfoFile = entity.DiffInfoFileName
+
+// ManifestFileName манифест архива обновления: версии, статусы, контрольные суммы, размеры и права файлов
+const ManifestFileName = entity.ManifestFileName
+
+// Описание содержимого архива обновления
+type (
+	Manifest     = entity.Manifest
+	ManifestFile = entity.ManifestFile
+)
+
+// change изменение одного файла при установке
+type change struct {
+	name     string // путь относительно каталога установки, через /
+	removed  bool
+	backedUp bool // прежний файл перенесен в резервную копию
+}
+
+// ApplyOptions параметры установки обновления
+type ApplyOptions struct {
+	// RemoveUnknown если архив содержит полную версию, то удалить файлы каталога установки, которых нет в версии:
+	// после установки каталог совпадает с версией. Пустые каталоги не удаляются.
+	// Удаляются и файлы, созданные не установщиком (настройки, логи), поэтому по умолчанию выключено
+	RemoveUnknown bool
+}
+
+// Apply установка обновления в каталог installDir с параметрами по умолчанию.
+// Файлы распаковываются во временный каталог рядом с installDir и сверяются с контрольными суммами из files (результат Check).
+// Затем файлы переносятся на место, прежние версии сохраняются в резервную копию.
+// Удаляются только файлы, которые манифест или список изменений отмечает как удаленные.
+// При любой ошибке изменения откатываются и каталог остается в исходном состоянии
+func (p *Package) Apply(installDir string, files []FileInfo) error {
+	return p.ApplyWithOptions(installDir, files, ApplyOptions{})
+}
+
+// ApplyWithOptions установка обновления в каталог installDir, см. Apply и ApplyOptions
+func (p *Package) ApplyWithOptions(installDir string, files []FileInfo, opts ApplyOptions) error {
+	installDir, err := filepath.Abs(installDir)
+	if err != nil {
+		return err
+	}
+
+	// временный каталог на той же файловой системе, чтобы перенос файлов был атомарным
+	work, err := ioutil.TempDir(filepath.Dir(installDir), ".updsrv-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+
+	stageDir := filepath.Join(work, "stage")
+	backupDir := filepath.Join(work, "backup")
+
+	changes, full, err := p.stage(stageDir, files)
+	if err != nil {
+		return err
+	}
+	if full && opts.RemoveUnknown {
+		stale, err := staleFiles(installDir, changes)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, stale...)
+	}
+
+	var applied []change
+	for _, ch := range changes {
+		if err := applyChange(&ch, installDir, stageDir, backupDir); err != nil {
+			if rbErr := rollback(applied, installDir, backupDir); rbErr != nil {
+				return fmt.Errorf("%v; rollback failed: %v", err, rbErr)
+			}
+			return err
+		}
+		applied = append(applied, ch)
+	}
+
+	return nil
+}
+
+// stage распаковка архива в stageDir с проверкой контрольных сумм. Возвращает список изменений
+// и признак полной версии. Изменения берутся из манифеста, а если его нет (старый сервер), то из текстового описания
+func (p *Package) stage(stageDir string, files []FileInfo) ([]change, bool, error) {
+	zr, err := zip.OpenReader(p.Path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer zr.Close()
+
+	var changes []change
+	var manifest *Manifest
+	var full bool
+	for _, zf := range zr.File {
+		switch zf.Name {
+		case ManifestFileName:
+			if manifest, err = readManifest(zf); err != nil {
+				return nil, false, err
+			}
+		case DiffInfoFile:
+			if manifest == nil {
+				if changes, full, err = readDiffInfo(zf); err != nil {
+					return nil, false, err
+				}
+			}
+		}
+	}
+
+	checksums := map[string]string{}
+	for _, f := range files {
+		checksums[f.Name] = f.Checksum
+	}
+	if manifest != nil {
+		full = manifest.Full
+		changes = []change{}
+		for _, f := range manifest.Files {
+			name, err := cleanName(f.Name)
+			if err != nil {
+				return nil, false, err
+			}
+			removed := f.Status == entity.FileRemoved
+			changes = append(changes, change{name: name, removed: removed})
+			if _, ok := checksums[name]; !ok && !removed {
+				checksums[name] = f.Sha256
+			}
+		}
+	}
+
+	extracted := map[string]bool{}
+	var all []change
+	for _, zf := range zr.File {
+		if strings.HasSuffix(zf.Name, "/") || zf.Name == ManifestFileName || zf.Name == DiffInfoFile {
+			continue
+		}
+
+		name, err := cleanName(zf.Name)
+		if err != nil {
+			return nil, false, err
+		}
+
+		checksum, ok := checksums[name]
+		if !ok {
+			return nil, false, fmt.Errorf("unexpected file in update: %s", name)
+		}
+		if err := extractFile(zf, filepath.Join(stageDir, filepath.FromSlash(name)), name, checksum); err != nil {
+			return nil, false, err
+		}
+
+		extracted[name] = true
+		all = append(all, change{name: name})
+	}
+
+	// без описания архив содержит полную версию
+	if changes == nil {
+		return all, true, nil
+	}
+
+	for _, ch := range changes {
+		if !ch.removed && !extracted[ch.name] {
+			return nil, false, fmt.Errorf("file missing in update: %s", ch.name)
+		}
+	}
+	return changes, full, nil
+}
+
+// staleFiles файлы каталога установки, которых нет в полной версии. Удаляются при установке с RemoveUnknown
+func staleFiles(installDir string, changes []change) ([]change, error) {
+	keep := map[string]bool{}
+	for _, ch := range changes {
+		if !ch.removed {
+			keep[ch.name] = true
+		}
+	}
+
+	var res []change
+	err := filepath.Walk(installDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && file == installDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(installDir, file)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); !keep[name] {
+			res = append(res, change{name: name, removed: true})
+		}
+		return nil
+	})
+	return res, err
+}
+
+// readManifest разбор манифеста архива
+func readManifest(zf *zip.File) (*Manifest, error) {
+	r, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", ManifestFileName, err)
+	}
+	if m.Format > entity.ManifestFormat {
+		return nil, fmt.Errorf("unsupported %s format: %d", ManifestFileName, m.Format)
+	}
+	return &m, nil
+}
+
+// readDiffInfo разбор файла со списком изменений. Строки "?" означают полную версию
+func readDiffInfo(zf *zip.File) ([]change, bool, error) {
+	r, err := zf.Open()
+	if err != nil {
+		return nil, false, err
+	}
+	defer r.Close()
+
+	res := []change{}
+	var full bool
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		if len(line) < 3 || line[1] != ' ' {
+			return nil, false, fmt.Errorf("invalid line in %s: %s", DiffInfoFile, line)
+		}
+
+		name, err := cleanName(line[2:])
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch line[0] {
+		case '+', '*':
+			res = append(res, change{name: name})
+		case '?': // файл полной версии
+			res = append(res, change{name: name})
+			full = true
+		case '-':
+			res = append(res, change{name: name, removed: true})
+		default:
+			return nil, false, fmt.Errorf("invalid line in %s: %s", DiffInfoFile, line)
+		}
+	}
+
+	return res, full, scanner.Err()
+}
+
+// cleanName проверка, что путь из архива не выходит за каталог установки
+func cleanName(name string) (string, error) {
+	clean := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if len(clean) == 0 || clean == "." || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") ||
+		filepath.VolumeName(filepath.FromSlash(clean)) != "" {
+		return "", fmt.Errorf("invalid file name in update: %s", name)
+	}
+	return clean, nil
+}
+
+// extractFile распаковка файла с проверкой контрольной суммы sha256. Права доступа, время изменения
+// и символические ссылки восстанавливаются из архива
+func extractFile(zf *zip.File, dst string, name string, checksum string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if zf.Mode()&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if err := verifyChecksum(target, checksum, name); err != nil {
+			return err
+		}
+		if err := checkLinkTarget(name, string(target)); err != nil {
+			return err
+		}
+		return os.Symlink(filepath.FromSlash(string(target)), dst)
+	}
+
+	mode := zf.Mode().Perm()
+	if mode == 0 {
+		mode = entity.DefaultFileMode
+	}
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, checksum) {
+		return fmt.Errorf("checksum mismatch: %s", name)
+	}
+
+	// права при создании файла урезаются umask
+	if err := os.Chmod(dst, mode); err != nil {
+		return err
+	}
+	if !zf.Modified.IsZero() {
+		return os.Chtimes(dst, zf.Modified, zf.Modified)
+	}
+	return nil
+}
+
+func verifyChecksum(data []byte, checksum string, name string) error {
+	sum := sha256.Sum256(data)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), checksum) {
+		return fmt.Errorf("checksum mismatch: %s", name)
+	}
+	return nil
+}
+
+// checkLinkTarget символическая ссылка не должна указывать за пределы каталога установки
+func checkLinkTarget(name string, target string) error {
+	target = strings.ReplaceAll(target, "\\", "/")
+	if path.IsAbs(target) {
+		return fmt.Errorf("absolute symlink target: %s -> %s", name, target)
+	}
+	if _, err := cleanName(path.Join(path.Dir(name), target)); err != nil {
+		return fmt.Errorf("symlink target outside install directory: %s -> %s", name, target)
+	}
+	return nil
+}
+
+// applyChange перенос файла из stageDir в каталог установки. Прежний файл переносится в backupDir
+func applyChange(ch *change, installDir string, stageDir string, backupDir string) error {
+	native := filepath.FromSlash(ch.name)
+	dst := filepath.Join(installDir, native)
+
+	if _, err := os.Lstat(dst); err == nil {
+		backup := filepath.Join(backupDir, native)
+		if err := os.MkdirAll(filepath.Dir(backup), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(dst, backup); err != nil {
+			return err
+		}
+		ch.backedUp = true
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if ch.removed {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(stageDir, native), dst); err != nil {
+		// файл не перенесен, возвращаем прежний
+		if ch.backedUp {
+			_ = os.Rename(filepath.Join(backupDir, native), dst)
+		}
+		return err
+	}
+	return nil
+}
+
+// rollback отмена примененных изменений в обратном порядке
+func rollback(applied []change, installDir string, backupDir string) error {
+	var errs []string
+	for i := len(applied) - 1; i >= 0; i-- {
+		ch := applied[i]
+		native := filepath.FromSlash(ch.name)
+		dst := filepath.Join(installDir, native)
+
+		if !ch.removed {
+			if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err.Error())
+				continue
+			}
+		}
+		if ch.backedUp {
+			if err := os.Rename(filepath.Join(backupDir, native), dst); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package client
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+func sum(data string) string {
+	h := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(h[:])
+}
+
+// testPackage архив обновления с файлами files и манифестом manifest (если не nil)
+func testPackage(t *testing.T, files map[string]string, manifest *Manifest) *Package {
+	t.Helper()
+
+	zipPath := filepath.Join(t.TempDir(), "update.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if manifest != nil {
+		manifest.Format = entity.ManifestFormat
+		w, err := zw.Create(ManifestFileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.NewEncoder(w).Encode(manifest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Package{Path: zipPath}
+}
+
+// testInstallDir каталог установки с файлами files
+func testInstallDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "app")
+	for name, data := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// readInstallDir содержимое каталога установки: имя через / и данные
+func readInstallDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	res := map[string]string{}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, file)
+		res[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func manifestFiles(t *testing.T, statuses map[string]string, files map[string]string) []ManifestFile {
+	t.Helper()
+
+	var res []ManifestFile
+	for name, status := range statuses {
+		f := ManifestFile{Name: name, Status: status}
+		if status != entity.FileRemoved {
+			f.Sha256 = sum(files[name])
+		}
+		res = append(res, f)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+func TestApply(t *testing.T) {
+	installed := map[string]string{"bin/app": "v1", "lib/old.so": "old", "readme.txt": "readme"}
+
+	tests := []struct {
+		name     string
+		files    map[string]string // файлы архива
+		statuses map[string]string // статусы файлов в манифесте. nil - без манифеста
+		full     bool
+		opts     ApplyOptions
+		want     map[string]string // содержимое каталога после установки
+		wantErr  bool
+	}{
+		{
+			name:     "diff",
+			files:    map[string]string{"bin/app": "v2", "lib/new.so": "new"},
+			statuses: map[string]string{"bin/app": entity.FileModified, "lib/new.so": entity.FileCreated, "lib/old.so": entity.FileRemoved},
+			want:     map[string]string{"bin/app": "v2", "lib/new.so": "new", "readme.txt": "readme"},
+		},
+		{
+			name:     "full manifest keeps unknown files",
+			files:    map[string]string{"bin/app": "v2", "lib/new.so": "new"},
+			statuses: map[string]string{"bin/app": entity.FileCreated, "lib/new.so": entity.FileCreated},
+			full:     true,
+			want:     map[string]string{"bin/app": "v2", "lib/new.so": "new", "lib/old.so": "old", "readme.txt": "readme"},
+		},
+		{
+			name:     "full manifest removes unknown files",
+			files:    map[string]string{"bin/app": "v2", "lib/new.so": "new"},
+			statuses: map[string]string{"bin/app": entity.FileCreated, "lib/new.so": entity.FileCreated},
+			full:     true,
+			opts:     ApplyOptions{RemoveUnknown: true},
+			want:     map[string]string{"bin/app": "v2", "lib/new.so": "new"},
+		},
+		{
+			name:  "no manifest is full version",
+			files: map[string]string{"bin/app": "v2"},
+			opts:  ApplyOptions{RemoveUnknown: true},
+			want:  map[string]string{"bin/app": "v2"},
+		},
+		{
+			name:     "missing file rolls back",
+			files:    map[string]string{"bin/app": "v2"},
+			statuses: map[string]string{"bin/app": entity.FileModified, "lib/new.so": entity.FileCreated},
+			want:     installed,
+			wantErr:  true,
+		},
+		{
+			name:     "unexpected file",
+			files:    map[string]string{"bin/app": "v2", "extra": "x"},
+			statuses: map[string]string{"bin/app": entity.FileModified},
+			want:     installed,
+			wantErr:  true,
+		},
+		{
+			name:     "path traversal",
+			files:    map[string]string{"../evil": "x"},
+			statuses: map[string]string{"../evil": entity.FileCreated},
+			want:     installed,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var manifest *Manifest
+			var checks []FileInfo // без манифеста контрольные суммы есть только в ответе /api/check
+			if tt.statuses != nil {
+				manifest = &Manifest{Full: tt.full, Files: manifestFiles(t, tt.statuses, tt.files)}
+			} else {
+				for name, data := range tt.files {
+					checks = append(checks, FileInfo{Name: name, Checksum: sum(data)})
+				}
+			}
+			pkg := testPackage(t, tt.files, manifest)
+			dir := testInstallDir(t, installed)
+
+			err := pkg.ApplyWithOptions(dir, checks, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := readInstallDir(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("install dir = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// ошибка контрольной суммы из ответа /api/check: каталог остается в исходном состоянии
+func TestApplyChecksumMismatch(t *testing.T) {
+	installed := map[string]string{"a": "1", "b": "1"}
+	files := map[string]string{"a": "2", "b": "2"}
+	pkg := testPackage(t, files, &Manifest{Files: manifestFiles(t,
+		map[string]string{"a": entity.FileModified, "b": entity.FileModified}, files)})
+	dir := testInstallDir(t, installed)
+
+	err := pkg.Apply(dir, []FileInfo{{Name: "a", Checksum: sum("2")}, {Name: "b", Checksum: sum("other")}})
+	if err == nil {
+		t.Fatal("checksum mismatch not detected")
+	}
+	if got := readInstallDir(t, dir); !reflect.DeepEqual(got, installed) {
+		t.Fatalf("install dir = %v, want %v", got, installed)
+	}
+}
+
+// ошибка при переносе файлов: примененные изменения откатываются
+func TestRollback(t *testing.T) {
+	installed := map[string]string{"a": "1", "old": "x"}
+	dir := testInstallDir(t, installed)
+	work := t.TempDir()
+	stageDir := filepath.Join(work, "stage")
+	backupDir := filepath.Join(work, "backup")
+
+	if err := os.MkdirAll(stageDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(stageDir, "a"), []byte("2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var applied []change
+	for _, ch := range []change{{name: "a"}, {name: "old", removed: true}} {
+		if err := applyChange(&ch, dir, stageDir, backupDir); err != nil {
+			t.Fatal(err)
+		}
+		applied = append(applied, ch)
+	}
+	// файла нет в stageDir
+	ch := change{name: "missing"}
+	if err := applyChange(&ch, dir, stageDir, backupDir); err == nil {
+		t.Fatal("missing staged file not detected")
+	}
+
+	if err := rollback(applied, dir, backupDir); err != nil {
+		t.Fatal(err)
+	}
+	if got := readInstallDir(t, dir); !reflect.DeepEqual(got, installed) {
+		t.Fatalf("install dir = %v, want %v", got, installed)
+	}
+}
@@ -0,0 +1,490 @@
+// Package client Клиент сервера обновлений: проверка наличия, загрузка и установка обновлений, публикация версий
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// Типы API сервера
+type (
+	Version        = entity.Version
+	UpdateInfo     = entity.UpdateInfo
+	FileInfo       = entity.FileInfo
+	CheckRequest   = entity.CheckRequest
+	InstallReport  = entity.InstallReport
+	PublishPreview = entity.PublishPreview
+	PublishReceipt = entity.PublishReceipt
+)
+
+// ParseVersion разбор версии вида 4.1.2.9
+func ParseVersion(s string) (Version, error) {
+	v, _, err := entity.ParseVersion(s)
+	return v, err
+}
+
+// StatusError ответ сервера с кодом ошибки
+type StatusError struct {
+	StatusCode int
+	Text       string        // текст ошибки от сервера
+	RetryAfter time.Duration // значение заголовка Retry-After для ответа 429
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Text) == 0 {
+		return http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s: %s", http.StatusText(e.StatusCode), e.Text)
+}
+
+// Client клиент сервера обновлений
+type Client struct {
+	server     string
+	token      string
+	http       *http.Client
+	retries    int
+	retryDelay time.Duration
+	clientID   string
+	applyOpts  ApplyOptions
+}
+
+// Option настройка клиента
+type Option func(*Client)
+
+// WithHTTPClient свой http клиент (таймауты, прокси, TLS)
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) {
+		c.http = h
+	}
+}
+
+// WithRetries количество повторов загрузки при сетевых ошибках, ответах 429 и 5xx. Задержка удваивается после каждой попытки
+func WithRetries(count int, delay time.Duration) Option {
+	return func(c *Client) {
+		c.retries = count
+		c.retryDelay = delay
+	}
+}
+
+// WithClientID постоянный идентификатор клиента для учета клиентов на сервере
+func WithClientID(id string) Option {
+	return func(c *Client) {
+		c.clientID = id
+	}
+}
+
+// WithApplyOptions параметры установки обновления в Update
+func WithApplyOptions(opts ApplyOptions) Option {
+	return func(c *Client) {
+		c.applyOpts = opts
+	}
+}
+
+// New создание клиента. server - адрес сервера вида http://localhost:8080, token - токен доступа
+func New(server string, token string, opts ...Option) *Client {
+	c := &Client{
+		server:     strings.TrimRight(server, "/"),
+		token:      token,
+		http:       &http.Client{Timeout: 10 * time.Minute},
+		retries:    3,
+		retryDelay: time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Check проверка наличия обновления. nil, если обновления нет
+func (c *Client) Check(ctx context.Context, channel string, version Version) (*UpdateInfo, error) {
+	resp, err := c.postJson(ctx, "/api/check", c.checkRequest(channel, version))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	var info UpdateInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Versions все версии канала, от новых к старым
+func (c *Client) Versions(ctx context.Context, channel string) ([]UpdateInfo, error) {
+	resp, err := c.do(ctx, "GET", "/api/versions?channel="+url.QueryEscape(channel), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res []UpdateInfo
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Report отправка отчета о результате установки обновления
+func (c *Client) Report(ctx context.Context, report InstallReport) error {
+	resp, err := c.postJson(ctx, "/api/report", report)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Package загруженное обновление: zip архив во временном файле
+type Package struct {
+	Channel   string
+	Version   Version
+	BuildTime string // время сборки в формате 2006-01-02T15:04
+	Path      string // путь к zip архиву
+	Size      int64
+}
+
+// Close удаление временного файла с архивом
+func (p *Package) Close() error {
+	return os.Remove(p.Path)
+}
+
+// Download загрузка обновления с повторами во временный файл. nil, если обновления нет.
+// После использования пакет нужно закрыть
+func (c *Client) Download(ctx context.Context, channel string, version Version) (*Package, error) {
+	file, err := ioutil.TempFile("", "updsrv*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var pkg *Package
+	err = c.retry(ctx, func() error {
+		pkg = nil
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		resp, err := c.postJson(ctx, "/api/update", c.checkRequest(channel, version))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+
+		p := &Package{
+			Channel:   channel,
+			BuildTime: resp.Header.Get("Version-Date"),
+			Path:      file.Name(),
+		}
+		if p.Version, err = versionFromHeaders(resp.Header); err != nil {
+			return err
+		}
+		if p.Size, err = io.Copy(file, resp.Body); err != nil {
+			return err
+		}
+		pkg = p
+		return nil
+	})
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil || pkg == nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return pkg, nil
+}
+
+// Update полный цикл обновления: проверка, загрузка, проверка контрольных сумм и установка в installDir.
+// Результат установки отправляется на сервер. Возвращает установленную версию или nil, если обновления нет
+func (c *Client) Update(ctx context.Context, channel string, version Version, installDir string) (*UpdateInfo, error) {
+	info, err := c.Check(ctx, channel, version)
+	if err != nil || info == nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	pkg, err := c.Download(ctx, channel, version)
+	if err != nil {
+		return nil, err
+	}
+	if pkg == nil {
+		// обновление успели отключить
+		return nil, nil
+	}
+	defer pkg.Close()
+
+	if pkg.Version != info.Version {
+		return nil, fmt.Errorf("version changed during download: %s, expected %s", pkg.Version.String(), info.Version.String())
+	}
+
+	err = pkg.ApplyWithOptions(installDir, info.Files, c.applyOpts)
+
+	report := InstallReport{
+		Channel:  channel,
+		From:     version,
+		To:       info.Version,
+		Success:  err == nil,
+		Duration: int(time.Since(start).Milliseconds()),
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	// ошибка отправки отчета не влияет на результат установки
+	_ = c.Report(ctx, report)
+
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// PublishRequest параметры публикуемой версии
+type PublishRequest struct {
+	Channel   string
+	Version   Version
+	Info      string
+	Enabled   bool
+	BuildTime time.Time // время сборки. Если не задано, то сервер использует текущее
+	// Base базовая версия того же канала для публикации наложением: архив содержит только новые и измененные файлы,
+	// остальные сервер берет из базовой версии. Только для Publish
+	Base    *Version
+	Removed []string // файлы базовой версии, которых нет в новой
+}
+
+// Publish публикация новой версии из архива zip, tar, tar.gz или tar.zst. Формат сервер определяет по содержимому.
+// При наложении на базовую версию archive может быть nil, если файлы только удаляются. Возвращает квитанцию о добавленной версии
+func (c *Client) Publish(ctx context.Context, req PublishRequest, archive io.Reader) (*PublishReceipt, error) {
+	resp, err := c.postPublish(ctx, req, archive, false)
+	if err != nil {
+		return nil, err
+	}
+	return decodeReceipt(resp)
+}
+
+func decodeReceipt(resp *http.Response) (*PublishReceipt, error) {
+	defer resp.Body.Close()
+
+	var receipt PublishReceipt
+	if err := json.NewDecoder(resp.Body).Decode(&receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// PreviewPublish пробная публикация: сервер проверяет архив и возвращает изменения относительно последней версии канала,
+// версия не добавляется
+func (c *Client) PreviewPublish(ctx context.Context, req PublishRequest, archive io.Reader) (*PublishPreview, error) {
+	resp, err := c.postPublish(ctx, req, archive, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var preview PublishPreview
+	if err := json.NewDecoder(resp.Body).Decode(&preview); err != nil {
+		return nil, err
+	}
+	return &preview, nil
+}
+
+func (c *Client) postPublish(ctx context.Context, req PublishRequest, archive io.Reader, dryRun bool) (*http.Response, error) {
+	values := publishValues(req)
+	if req.Base != nil {
+		values["base"] = req.Base.String()
+		values["removed"] = strings.Join(req.Removed, "\n")
+	}
+	if dryRun {
+		values["dryRun"] = "true"
+	}
+
+	// multipart формируется на лету, чтобы не держать архив в памяти
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeForm(form, archive, values))
+	}()
+
+	resp, err := c.do(ctx, "POST", "/api/add", form.FormDataContentType(), body)
+	if err != nil {
+		body.CloseWithError(err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// поля формы с параметрами новой версии
+func publishValues(req PublishRequest) map[string]string {
+	values := map[string]string{
+		"channel": req.Channel,
+		"version": req.Version.String(),
+		"info":    req.Info,
+		"enabled": strconv.FormatBool(req.Enabled),
+	}
+	if !req.BuildTime.IsZero() {
+		values["buildTime"] = req.BuildTime.Format("2006-01-02T15:04")
+	}
+	return values
+}
+
+func writeForm(form *multipart.Writer, archive io.Reader, values map[string]string) error {
+	for name, value := range values {
+		if len(value) == 0 {
+			continue
+		}
+		if err := form.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+
+	if archive != nil {
+		part, err := form.CreateFormFile("update", "update")
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, archive); err != nil {
+			return err
+		}
+	}
+
+	return form.Close()
+}
+
+func (c *Client) checkRequest(channel string, version Version) CheckRequest {
+	return CheckRequest{
+		ClientID: c.clientID,
+		Channel:  channel,
+		Version:  version,
+	}
+}
+
+func (c *Client) postJson(ctx context.Context, path string, v interface{}) (*http.Response, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(ctx, "POST", path, "application/json", bytes.NewReader(data))
+}
+
+// do выполнение запроса. Ответ с кодом, отличным от 2xx, возвращается как *StatusError
+func (c *Client) do(ctx context.Context, method string, path string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return c.send(req)
+}
+
+// send отправка запроса с токеном. Ответ с кодом, отличным от 2xx, возвращается как *StatusError
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if len(c.token) > 0 {
+		req.Header.Set("X-Authorization", c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		text, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		res := &StatusError{
+			StatusCode: resp.StatusCode,
+			Text:       strings.TrimSpace(string(text)),
+		}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			res.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, res
+	}
+
+	return resp, nil
+}
+
+// retry выполнение f с повторами при временных ошибках
+func (c *Client) retry(ctx context.Context, f func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= c.retries || !temporary(err) {
+			return err
+		}
+
+		delay := c.retryDelay << attempt
+		var se *StatusError
+		if errors.As(err, &se) && se.RetryAfter > delay {
+			delay = se.RetryAfter
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// temporary можно ли повторить запрос после ошибки
+func temporary(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500
+	}
+
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// версия из заголовков Version-* ответа /api/update
+func versionFromHeaders(h http.Header) (Version, error) {
+	var v Version
+	for _, f := range []struct {
+		name string
+		dst  *int
+	}{
+		{"Version-Major", &v.Major},
+		{"Version-Minor", &v.Minor},
+		{"Version-Patch", &v.Patch},
+		{"Version-Revision", &v.Revision},
+	} {
+		n, err := strconv.Atoi(h.Get(f.name))
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid %s header: %v", f.name, err)
+		}
+		*f.dst = n
+	}
+	return v, nil
+}
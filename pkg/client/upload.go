@@ -0,0 +1,169 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/n-r-w/updsrv/internal/entity"
+)
+
+// UploadSession сессия загрузки обновления частями
+type UploadSession = entity.UploadSession
+
+// PublishChunked публикация архива частями по chunkSize байт, каждая часть с повторами при временных ошибках.
+// Для архивов больше MAX_UPDATE_SIZE сервера. Возвращает квитанцию о добавленной версии,
+// ошибка содержит идентификатор сессии для ResumeUpload
+func (c *Client) PublishChunked(ctx context.Context, req PublishRequest, archive io.ReaderAt, size int64, chunkSize int64) (*PublishReceipt, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	if req.Base != nil {
+		return nil, fmt.Errorf("chunked upload does not support publishing on top of a base version")
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(archive, 0, size)); err != nil {
+		return nil, err
+	}
+
+	session, err := c.CreateUpload(ctx, req, size, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return nil, err
+	}
+
+	receipt, err := c.uploadChunks(ctx, session, archive, size, chunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("upload %s: %w", session.ID, err)
+	}
+	return receipt, nil
+}
+
+// ResumeUpload продолжение загрузки частями с первой отсутствующей на сервере части и завершение.
+// Архив и размер части должны быть те же, что и при PublishChunked
+func (c *Client) ResumeUpload(ctx context.Context, id string, archive io.ReaderAt, size int64, chunkSize int64) (*PublishReceipt, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+
+	session, err := c.UploadStatus(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Received != int64(session.NextChunk)*chunkSize && session.Received != size {
+		return nil, fmt.Errorf("upload %s: received %d bytes does not match chunk size %d", id, session.Received, chunkSize)
+	}
+
+	receipt, err := c.uploadChunks(ctx, session, archive, size, chunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("upload %s: %w", session.ID, err)
+	}
+	return receipt, nil
+}
+
+func (c *Client) uploadChunks(ctx context.Context, session *UploadSession, archive io.ReaderAt, size int64, chunkSize int64) (*PublishReceipt, error) {
+	for number := session.NextChunk; int64(number)*chunkSize < size; number++ {
+		offset := int64(number) * chunkSize
+		data := make([]byte, chunkSize)
+		if offset+chunkSize > size {
+			data = data[:size-offset]
+		}
+		if _, err := archive.ReadAt(data, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		err := c.retry(ctx, func() error {
+			_, err := c.UploadChunk(ctx, session.ID, number, data)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", number, err)
+		}
+	}
+
+	return c.FinalizeUpload(ctx, session.ID)
+}
+
+// CreateUpload создание сессии загрузки частями. size и checksum (sha256 в hex) архива проверяются сервером, если заданы
+func (c *Client) CreateUpload(ctx context.Context, req PublishRequest, size int64, checksum string) (*UploadSession, error) {
+	values := url.Values{}
+	for name, value := range publishValues(req) {
+		values.Set(name, value)
+	}
+	if size > 0 {
+		values.Set("size", strconv.FormatInt(size, 10))
+	}
+	if len(checksum) > 0 {
+		values.Set("checksum", checksum)
+	}
+
+	resp, err := c.do(ctx, "POST", "/api/uploads/create", "application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	return decodeSession(resp)
+}
+
+// UploadChunk загрузка части архива с номером number (с 0)
+func (c *Client) UploadChunk(ctx context.Context, id string, number int, data []byte) (*UploadSession, error) {
+	sum := sha256.Sum256(data)
+
+	req, err := http.NewRequestWithContext(ctx, "PUT",
+		fmt.Sprintf("%s/api/uploads/chunk?id=%s&number=%d", c.server, url.QueryEscape(id), number), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Chunk-Checksum", hex.EncodeToString(sum[:]))
+
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSession(resp)
+}
+
+// UploadStatus состояние сессии загрузки: полученные части и сколько байт получено подряд с начала архива
+func (c *Client) UploadStatus(ctx context.Context, id string) (*UploadSession, error) {
+	resp, err := c.do(ctx, "GET", "/api/uploads/status?id="+url.QueryEscape(id), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSession(resp)
+}
+
+// FinalizeUpload завершение загрузки и публикация версии
+func (c *Client) FinalizeUpload(ctx context.Context, id string) (*PublishReceipt, error) {
+	resp, err := c.do(ctx, "POST", "/api/uploads/finalize?id="+url.QueryEscape(id), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeReceipt(resp)
+}
+
+// CancelUpload отмена загрузки
+func (c *Client) CancelUpload(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, "POST", "/api/uploads/cancel?id="+url.QueryEscape(id), "", nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func decodeSession(resp *http.Response) (*UploadSession, error) {
+	defer resp.Body.Close()
+
+	var session UploadSession
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}